@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mtulio/azion-exporter/src/azion"
 	"github.com/mtulio/azion-exporter/src/collector"
@@ -21,14 +26,21 @@ type globalProm struct {
 }
 
 type configParams struct {
-	azionEmail     *string
-	azionPass      *string
-	apiListenAddr  *string
-	apiMetricsPath *string
-	prom           *globalProm
-	azionClient    *azion.Client
-	metricsName    []string
-	metricInterval *int
+	azionEmail      *string
+	azionPass       *string
+	apiListenAddr   *string
+	apiMetricsPath  *string
+	prom            *globalProm
+	azionClient     *azion.Client
+	azionAPIStats   *azion.Instrumentation
+	collectorConfig collector.Config
+	metricInterval  *int
+	tracingFile     *string
+	tracingShutdown func(context.Context) error
+	timeoutOffset   time.Duration
+	breakers        map[string]*azion.BreakerSettings
+	auth            azion.AuthSettings
+	rateLimit       azion.RateLimitSettings
 }
 
 const (
@@ -37,10 +49,29 @@ const (
 )
 
 var (
-	cfg               = configParams{}
-	defAPIListenAddr  = ":9801"
-	defAPIMetricsPath = "/metrics"
-	defMetricInterval = 60
+	cfg                 = configParams{}
+	defAPIListenAddr    = ":9801"
+	defAPIMetricsPath   = "/metrics"
+	defMetricInterval   = 60
+	defTracingFile      = "azion-exporter-traces.json"
+	defShutdownTimeout  = 5 * time.Second
+	defDiscoverInterval = time.Hour
+	defFamilyMaxSeries  = 50
+	defMetricJitter     = 5 * time.Second
+	defMetricWorkers    = 4
+	defMetricSpread     = 0.5
+	defStaleIntervals   = 5
+	defMinAge           = 30 * time.Second
+	defScrapeTimeout    = 10 * time.Second
+	defTimeoutOffset    = 500 * time.Millisecond
+	defBreakerFailures  = map[string]int{
+		azion.EndpointClassAuth:      3,
+		azion.EndpointClassAnalytics: 5,
+	}
+	defBreakerTimeout = map[string]time.Duration{
+		azion.EndpointClassAuth:      5 * time.Minute,
+		azion.EndpointClassAnalytics: time.Minute,
+	}
 )
 
 // usage returns the command line usage sample.
@@ -58,8 +89,50 @@ func init() {
 	cfg.azionEmail = flag.String("azion.email", "", "API email address to get Authorization token")
 	cfg.azionPass = flag.String("azion.password", "", "API password to get Authorization token")
 
-	fMetricsFilter := flag.String("metrics.filter", "", "List of metrics sepparated by comma")
+	fMetricsFilter := flag.String("metrics.filter", "", "List of metrics sepparated by comma, accepting globs (cd_status_code_*) and exclusions (!cd_status_code_204). Default metrics are used when no metric is included")
+	fMetricsList := flag.Bool("metrics.list", false, "Print the metrics resolved by -metrics.filter and exit")
 	cfg.metricInterval = flag.Int("metrics.interval", defMetricInterval, "Interval in seconds to retrieve metrics from API")
+	fMetricsSchedule := flag.String("metrics.schedule", "", "List of interval overrides sepparated by comma in the format pattern=interval, matching the metric or product (cd_status_code_*=1m,cd_bandwidth_*=5m)")
+	flag.Float64Var(&cfg.collectorConfig.Spread, "metrics.spread", defMetricSpread, "Fraction of the interval (0-1) the fetches are distributed across, by metric name")
+	flag.DurationVar(&cfg.collectorConfig.Jitter, "metrics.jitter", defMetricJitter, "Maximum random delay of each fetch after the interval boundary")
+	flag.IntVar(&cfg.collectorConfig.Workers, "metrics.workers", defMetricWorkers, "Maximum number of concurrent metric fetches")
+	flag.BoolVar(&cfg.collectorConfig.Timestamps, "metrics.timestamps", false, "Export the samples with the timestamp of the Azion datapoint instead of the scrape time")
+	flag.IntVar(&cfg.collectorConfig.StaleIntervals, "metrics.stale-intervals", defStaleIntervals, "Polling intervals without a successful fetch after which a series is stale, 0 disables")
+	flag.StringVar(&cfg.collectorConfig.StaleMode, "metrics.stale-mode", collector.StaleDrop, "Export of the stale series: drop or nan")
+	fMetricsStrategy := flag.String("metrics.strategy", "", "List of datapoint strategies sepparated by comma in the format pattern=strategy, matching the metric or product (cd_status_code_*=latest,cd_requests_*=sum:5)")
+	flag.StringVar(&cfg.collectorConfig.Mode, "collection.mode", collector.ModeBackground, "Collection mode: background fetching the metrics on each interval, or scrape fetching them on each scrape")
+	flag.DurationVar(&cfg.collectorConfig.MinAge, "collection.min-age", defMinAge, "Age of the results reused by the scrapes in scrape mode")
+	flag.DurationVar(&cfg.timeoutOffset, "collection.timeout-offset", defTimeoutOffset, "Offset subtracted from the Prometheus scrape timeout in scrape mode")
+	fMetricsCounter := flag.String("metrics.counter", "", "List of metric patterns sepparated by comma, matching the metric or product, exported as counters with suffix _total accumulating the per-minute datapoints (cd_requests_*,cd_status_code_*)")
+	flag.BoolVar(&cfg.collectorConfig.Incremental, "metrics.incremental", false, "Request only the datapoints newer than the newest settled one fetched, instead of the last hour (experimental)")
+	fMetricsSettleLag := flag.String("metrics.settle-lag", "auto", "Number of latest datapoints still processed by Azion, skipped by the strategies and counters, or auto to estimate it by metric")
+	flag.BoolVar(&cfg.collectorConfig.LegacyNames, "metrics.legacy-names", false, "Export the gauges with the legacy names too (azion_cd_bandwidth_gb), in the Azion units")
+	fMetricsFamily := flag.String("metrics.family", "", "List of metric families sepparated by comma (cd_status_code), exporting one series by dimension found in the API response or metadata")
+	flag.IntVar(&cfg.collectorConfig.FamilyMaxSeries, "metrics.family.max-series", defFamilyMaxSeries, "Maximum series exported by metric family")
+	flag.BoolVar(&cfg.collectorConfig.Discover, "metrics.discover", false, "Discover the metrics from the analytics metadata, selected by -metrics.filter")
+	flag.DurationVar(&cfg.collectorConfig.DiscoverInterval, "metrics.discover.interval", defDiscoverInterval, "Interval to refresh the discovered metrics")
+
+	flag.IntVar(&cfg.auth.MaxRejections, "azion.auth.max-rejections", 5,
+		"Consecutive 401/403 login responses stopping the login attempts until the credentials change (0 disables)")
+	flag.DurationVar(&cfg.auth.BackoffBase, "azion.auth.backoff", 30*time.Second, "Initial wait time between failed login attempts, doubled on each failure")
+	flag.DurationVar(&cfg.auth.BackoffMax, "azion.auth.backoff-max", 30*time.Minute, "Maximum wait time between failed login attempts")
+
+	flag.Float64Var(&cfg.rateLimit.Threshold, "azion.ratelimit.threshold", 0.2,
+		"Fraction of the API quota remaining that starts spreading the requests until the window resets (0 disables)")
+	flag.DurationVar(&cfg.rateLimit.MaxDelay, "azion.ratelimit.max-delay", 30*time.Second, "Maximum time a request waits for the API quota")
+	flag.DurationVar(&cfg.rateLimit.Window, "azion.ratelimit.window", time.Minute, "Quota window assumed when the API reports the quota without its reset")
+
+	cfg.breakers = make(map[string]*azion.BreakerSettings)
+	for _, class := range []string{azion.EndpointClassAuth, azion.EndpointClassAnalytics} {
+		bs := &azion.BreakerSettings{}
+		flag.IntVar(&bs.FailureThreshold, "azion.breaker."+class+".failures", defBreakerFailures[class],
+			"Consecutive failures of "+class+" API requests opening the circuit breaker")
+		flag.DurationVar(&bs.OpenTimeout, "azion.breaker."+class+".timeout", defBreakerTimeout[class],
+			"Time the circuit breaker of "+class+" API requests stays open before a probe request")
+		cfg.breakers[class] = bs
+	}
+
+	cfg.tracingFile = flag.String("tracing.file", defTracingFile, "File to write the spans when OTEL_TRACES_EXPORTER=file")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -72,20 +145,85 @@ func init() {
 		*cfg.azionPass = os.Getenv("AZION_PASSWORD")
 	}
 
-	if len(*fMetricsFilter) > 0 {
-		for _, m := range strings.Split(*fMetricsFilter, ",") {
-			cfg.metricsName = append(cfg.metricsName, m)
-		}
+	cfg.collectorConfig.Filter = strings.Split(*fMetricsFilter, ",")
+	cfg.collectorConfig.Families = strings.Split(*fMetricsFamily, ",")
+	cfg.collectorConfig.Counters = strings.Split(*fMetricsCounter, ",")
+
+	if *cfg.metricInterval <= 0 {
+		log.Fatalln("Invalid -metrics.interval: must be greater than 0")
+	}
+	cfg.collectorConfig.Interval = time.Duration(*cfg.metricInterval) * time.Second
+	if cfg.collectorConfig.Spread < 0 || cfg.collectorConfig.Spread > 1 {
+		log.Fatalln("Invalid -metrics.spread: must be between 0 and 1")
+	}
+	var err error
+	cfg.collectorConfig.Schedules, err = collector.ParseSchedules(*fMetricsSchedule)
+	if err != nil {
+		log.Fatalln("Invalid -metrics.schedule:", err)
+	}
+	cfg.collectorConfig.SettleLag, err = collector.ParseSettleLag(*fMetricsSettleLag)
+	if err != nil {
+		log.Fatalln("Invalid -metrics.settle-lag:", err)
+	}
+	cfg.collectorConfig.Strategies, err = collector.ParseStrategies(*fMetricsStrategy)
+	if err != nil {
+		log.Fatalln("Invalid -metrics.strategy:", err)
+	}
+
+	cfg.tracingShutdown, err = initTracing()
+	if err != nil {
+		log.Errorln("Init Tracing: Couldn't configure OpenTelemetry:", err)
 	}
 
 	cfg.azionClient = azion.NewClient(*cfg.azionEmail, *cfg.azionPass)
+	cfg.azionAPIStats = azion.NewInstrumentation()
+	cfg.azionClient.SetInstrumentation(cfg.azionAPIStats)
+	cfg.azionClient.SetAuthSettings(cfg.auth)
+	cfg.azionClient.SetRateLimitSettings(cfg.rateLimit)
+	for class, bs := range cfg.breakers {
+		cfg.azionClient.SetBreakerSettings(class, *bs)
+	}
 
-	initPromCollector()
+	if *fMetricsList {
+		metrics, err := collector.ListMetrics(cfg.azionClient, &cfg.collectorConfig)
+		if err != nil {
+			shutdownTracing()
+			log.Fatalln("Invalid -metrics.filter:", err)
+		}
+		for _, m := range metrics {
+			fmt.Println(m)
+		}
+		shutdownTracing()
+		os.Exit(0)
+	}
+
+	if err := initPromCollector(); err != nil {
+		shutdownTracing()
+		log.Fatalln("Init Prom: Couldn't initialize the exporter:", err)
+	}
+}
+
+// shutdownTracing flushes the pending spans before the process exits.
+func shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), defShutdownTimeout)
+	defer cancel()
+	if err := cfg.tracingShutdown(ctx); err != nil {
+		log.Errorln("Tracing: Couldn't flush the spans:", err)
+	}
 }
 
 // Main Prometheus handler
 func handler(w http.ResponseWriter, r *http.Request) {
 
+	if cfg.collectorConfig.Mode == collector.ModeScrape {
+		ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r))
+		err := cfg.prom.Collector.Refresh(ctx)
+		cancel()
+		if err != nil {
+			log.Warnln("Scrape: metrics not refreshed in time, exporting the previous results:", err)
+		}
+	}
+
 	// Delegate http serving to Prometheus client library, which will call collector.Collect.
 	h := promhttp.InstrumentMetricHandler(
 		cfg.prom.Registry,
@@ -98,6 +236,22 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	h.ServeHTTP(w, r)
 }
 
+// scrapeTimeout return the time the metrics can be fetched in, the Prometheus
+// scrape timeout from the X-Prometheus-Scrape-Timeout-Seconds header minus
+// the offset.
+func scrapeTimeout(r *http.Request) time.Duration {
+	timeout := defScrapeTimeout
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if s, err := strconv.ParseFloat(v, 64); err == nil && s > 0 {
+			timeout = time.Duration(s * float64(time.Second))
+		}
+	}
+	if timeout > cfg.timeoutOffset {
+		timeout -= cfg.timeoutOffset
+	}
+	return timeout
+}
+
 func main() {
 	log.Infoln("Starting exporter ")
 
@@ -116,6 +270,19 @@ func main() {
 	})
 
 	log.Info("Beginning to serve on port " + *cfg.apiListenAddr)
-	log.Fatal(http.ListenAndServe(*cfg.apiListenAddr, nil))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- http.ListenAndServe(*cfg.apiListenAddr, nil)
+	}()
 
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		shutdownTracing()
+		log.Fatal(err)
+	case s := <-sig:
+		log.Infoln("Received", s, "stopping exporter")
+		shutdownTracing()
+	}
 }
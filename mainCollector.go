@@ -13,7 +13,7 @@ func initPromCollector() error {
 		cfg.prom = new(globalProm)
 	}
 
-	cfg.prom.Collector, err = collector.NewCollectorMaster(cfg.azionClient, cfg.metricsName...)
+	cfg.prom.Collector, err = collector.NewCollectorMaster(cfg.azionClient, &cfg.collectorConfig)
 	if err != nil {
 		log.Warnln("Init Prom: Couldn't create collector: ", err)
 		return err
@@ -26,6 +26,12 @@ func initPromCollector() error {
 		return err
 	}
 
+	err = cfg.prom.Registry.Register(cfg.azionAPIStats)
+	if err != nil {
+		log.Errorln("Init Prom: Couldn't register API instrumentation:", err)
+		return err
+	}
+
 	cfg.prom.Gatherers = &prometheus.Gatherers{
 		prometheus.DefaultGatherer,
 		cfg.prom.Registry,
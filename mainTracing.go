@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/prometheus/common/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// initTracing configures the OpenTelemetry tracer provider from the standard
+// OTEL environment variables. Tracing is disabled unless OTEL_TRACES_EXPORTER
+// is "console" (stdout) or "file" (written to -tracing.file).
+// Sampling is configured by OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG,
+// and the resource by OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES.
+// The returned function flushes the pending spans and closes the exporter,
+// it must be called before the process exits.
+func initTracing() (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if strings.ToLower(os.Getenv("OTEL_SDK_DISABLED")) == "true" {
+		return noop, nil
+	}
+
+	var w io.Writer
+	closeWriter := noop
+	switch exp := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exp {
+	case "", "none":
+		return noop, nil
+	case "console", "stdout":
+		w = os.Stdout
+	case "file":
+		f, err := os.OpenFile(*cfg.tracingFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return noop, err
+		}
+		w = f
+		closeWriter = func(context.Context) error { return f.Close() }
+	default:
+		return noop, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q, supported: none, console, file", exp)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		closeWriter(context.Background())
+		return noop, err
+	}
+
+	// resources from environment take precedence over the default service name.
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", exporterName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		closeWriter(context.Background())
+		return noop, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	log.Infoln("Tracing enabled, exporting spans to", os.Getenv("OTEL_TRACES_EXPORTER"))
+
+	// the provider flushes the last batch before the file is closed
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if errC := closeWriter(ctx); err == nil {
+			err = errC
+		}
+		return err
+	}, nil
+}
@@ -1,10 +1,30 @@
 package azion
 
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultInflightTimeout bounds the shared API call of identical queries,
+// which outlives the callers leaving before the response.
+const defaultInflightTimeout = 2 * time.Minute
+
 // AnalyticsSvc handles communication with the Azion API methods related to
 // Analytics.
 type AnalyticsSvc struct {
 	client  *Client
 	BaseURI string
+
+	// InflightTimeout bounds the API call shared by identical queries. The
+	// call ends at the latest of this timeout and the deadline of the caller
+	// starting it.
+	InflightTimeout time.Duration
+
+	// inflight de-duplicates concurrent identical queries.
+	inflight singleflight.Group
 }
 
 // Analytics represents a Azion Analytics.
@@ -22,11 +42,11 @@ type AnalyticsMetric struct {
 // AnalyticsMetricDim represents a Azion Analytics Metric dimensions.
 type AnalyticsMetricDim map[string][]string
 
-// MetricResp is a metric response payload returned by Azion API.
-//
-// Azion API docs: https://www.azion.com.br/developers/api-v2/analytics/
-// type MetricResp map[string]map[string]map[string]map[string][][]interface{}
-type MetricResp interface{}
+// AnalyticsMetadata represents the metrics and dimensions available by
+// product ID on Azion Analytics.
+type AnalyticsMetadata struct {
+	Products map[string]map[string][]string `json:"products"`
+}
 
 // GetMatadata returns the metadata path values.
 //
@@ -47,9 +67,42 @@ func (a *AnalyticsSvc) GetMatadata() (*AnalyticsMetricDim, error) {
 	return dimensionsResponse, nil
 }
 
+// GetMetadataWithContext returns the metrics and dimensions available by
+// product, sending the request with the context ctx.
+//
+// Azion API docs: https://www.azion.com.br/developers/api-v2/analytics/
+func (a *AnalyticsSvc) GetMetadataWithContext(ctx context.Context) (*AnalyticsMetadata, error) {
+	req, err := a.client.NewRequest("GET", a.BaseURI+"/metadata", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	metadata := new(AnalyticsMetadata)
+
+	_, err = a.client.Do(req, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
 // getMetricDimension return the metric with dimensions
-func (a *AnalyticsSvc) getMetricDimension(pid, mc, dim string, qArgs ...string) (*MetricResp, error) {
-	url := a.BaseURI + "/products/" + pid + "/aggregate/metrics/" + mc + "/dimensions/" + dim + "?"
+func (a *AnalyticsSvc) getMetricDimension(ctx context.Context, pid, mc, dim string, qArgs ...string) (*MetricSeries, error) {
+	url := a.BaseURI + "/products/" + pid + "/aggregate/metrics/" + mc + "/dimensions/" + dim
+	return a.getMetric(ctx, withQueryArgs(url, qArgs...))
+}
+
+// getMetricAllDimensions return the metric with all its dimensions
+func (a *AnalyticsSvc) getMetricAllDimensions(ctx context.Context, pid, mc string, qArgs ...string) (*MetricSeries, error) {
+	url := a.BaseURI + "/products/" + pid + "/aggregate/metrics/" + mc
+	return a.getMetric(ctx, withQueryArgs(url, qArgs...))
+}
+
+// withQueryArgs return the URL with the query arguments appended
+func withQueryArgs(url string, qArgs ...string) string {
+	url += "?"
 	argCnt := 0
 	for _, value := range qArgs {
 		if argCnt == 0 {
@@ -59,29 +112,68 @@ func (a *AnalyticsSvc) getMetricDimension(pid, mc, dim string, qArgs ...string)
 			url += "&" + value
 		}
 	}
-	return a.getMetric(url)
+	return url
 }
 
-// getMetric return the metric requested by URL
-func (a *AnalyticsSvc) getMetric(url string) (*MetricResp, error) {
+// getMetric return the metric requested by URL, decoded from the response
+// body into the series. Concurrent requests to the same resolved URL share
+// one API call and one decoded response, which must be treated as read-only
+// by the callers. The shared call does not end with the context of the caller
+// starting it: each caller waits for the response until its own context is
+// done.
+func (a *AnalyticsSvc) getMetric(ctx context.Context, url string) (*MetricSeries, error) {
 
 	req, err := a.client.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	metrics := new(MetricResp)
+	executed := atomic.Bool{}
+	ch := a.inflight.DoChan(req.Method+" "+req.URL.String(), func() (interface{}, error) {
+		executed.Store(true)
+		a.client.Instrumentation.observeSingleflight(true)
 
-	_, err = a.client.Do(req, &metrics)
-	if err != nil {
-		return nil, err
+		sctx, cancel := a.inflightContext(ctx)
+		defer cancel()
+
+		metrics := new(MetricSeries)
+		_, err := a.client.Do(req.WithContext(sctx), metrics)
+		if err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	})
+
+	select {
+	case res := <-ch:
+		if !executed.Load() {
+			a.client.Instrumentation.observeSingleflight(false)
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*MetricSeries), nil
+	case <-ctx.Done():
+		if !executed.Load() {
+			a.client.Instrumentation.observeSingleflight(false)
+		}
+		return nil, context.Cause(ctx)
 	}
+}
 
-	return metrics, nil
+// inflightContext return the context of the call shared by identical queries,
+// keeping the values of ctx without its cancellation, and ending at the latest
+// of the InflightTimeout and the deadline of ctx.
+func (a *AnalyticsSvc) inflightContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(a.InflightTimeout)
+	if d, ok := ctx.Deadline(); ok && d.After(deadline) {
+		deadline = d
+	}
+	return context.WithDeadlineCause(context.WithoutCancel(ctx), deadline, ErrTimeout)
 }
 
-// getProductID return the ProductID when an Alias is specified
-func (a *AnalyticsSvc) getProductID(product string) string {
+// ProductID return the ProductID when an Alias is specified
+func (a *AnalyticsSvc) ProductID(product string) string {
 	switch product {
 	case "ContentDelivery":
 		return "1441740010"
@@ -98,11 +190,40 @@ func (a *AnalyticsSvc) getProductID(product string) string {
 	}
 }
 
+// ProductAlias return the Alias of a ProductID, or the ProductID when it has
+// no Alias.
+func (a *AnalyticsSvc) ProductAlias(productID string) string {
+	for _, alias := range []string{"ContentDelivery", "CloudStorage", "ImageOptimization", "LiveIngest", "MediaPackager"} {
+		if a.ProductID(alias) == productID {
+			return alias
+		}
+	}
+	return productID
+}
+
 //
 // CD: Content Delivery (1441740010)
 //
 
 // GetMetricDimension return the metric with dimensions for product Content Delivery
-func (a *AnalyticsSvc) GetMetricDimension(metric, dimension string, qArgs ...string) (*MetricResp, error) {
-	return a.getMetricDimension(a.getProductID("ContentDelivery"), metric, dimension, qArgs...)
+func (a *AnalyticsSvc) GetMetricDimension(metric, dimension string, qArgs ...string) (*MetricSeries, error) {
+	return a.GetMetricDimensionWithContext(context.Background(), metric, dimension, qArgs...)
+}
+
+// GetMetricDimensionWithContext return the metric with dimensions for product
+// Content Delivery, sending the request with the context ctx.
+func (a *AnalyticsSvc) GetMetricDimensionWithContext(ctx context.Context, metric, dimension string, qArgs ...string) (*MetricSeries, error) {
+	return a.GetProductMetricDimensionWithContext(ctx, "ContentDelivery", metric, dimension, qArgs...)
+}
+
+// GetProductMetricDimensionWithContext return the metric with dimensions for
+// a product ID or Alias, sending the request with the context ctx.
+func (a *AnalyticsSvc) GetProductMetricDimensionWithContext(ctx context.Context, product, metric, dimension string, qArgs ...string) (*MetricSeries, error) {
+	return a.getMetricDimension(ctx, a.ProductID(product), metric, dimension, qArgs...)
+}
+
+// GetProductMetricWithContext return the metric with all its dimensions for
+// a product ID or Alias, sending the request with the context ctx.
+func (a *AnalyticsSvc) GetProductMetricWithContext(ctx context.Context, product, metric string, qArgs ...string) (*MetricSeries, error) {
+	return a.getMetricAllDimensions(ctx, a.ProductID(product), metric, qArgs...)
 }
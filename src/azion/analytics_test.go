@@ -0,0 +1,222 @@
+package azion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSeriesBody return a metric response with one hour of datapoints of the
+// requests of Content Delivery.
+func testSeriesBody(now time.Time) string {
+	dps := []string{}
+	for i := 59; i >= 0; i-- {
+		ts := now.Add(-time.Duration(i) * time.Minute).Format(DatapointLayout)
+		dps = append(dps, fmt.Sprintf(`["%s", %d.5]`, ts, 1000+i))
+	}
+	return `{"products":{"1441740010":{"requests":{"total":[` + strings.Join(dps, ",") + `]}}}}`
+}
+
+// testServer return an API server answering the token requests and the
+// metric requests with body.
+func testServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tokens" {
+			fmt.Fprint(w, `{"token":"abc","created_at":"2030-01-01 00:00:00","expires_at":"2030-01-01 00:00:00.000"}`)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestGetMetric(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	srv := testServer(testSeriesBody(now))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	c := NewClientWithBaseURL(u, "user", "pass")
+
+	ms, err := c.Analytics.GetProductMetricDimensionWithContext(context.Background(), "ContentDelivery", "requests", "total", "date_from=last-hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dps := ms.Datapoints("1441740010", "requests", "total")
+	if len(dps) != 60 {
+		t.Fatalf("datapoints = %d, want 60", len(dps))
+	}
+	if last := dps[len(dps)-1]; !last.Time.Equal(now) || last.Value != 1000.5 || last.Null {
+		t.Errorf("last datapoint = %+v, want %v 1000.5", last, now)
+	}
+}
+
+func TestGetMetricShared(t *testing.T) {
+	body := testSeriesBody(time.Now().UTC().Truncate(time.Minute))
+	var calls atomic.Int32
+	received := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tokens" {
+			fmt.Fprint(w, `{"token":"abc","created_at":"2030-01-01 00:00:00","expires_at":"2030-01-01 00:00:00.000"}`)
+			return
+		}
+		if calls.Add(1) == 1 {
+			close(received)
+		}
+		<-release
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	c := NewClientWithBaseURL(u, "user", "pass")
+	inst := NewInstrumentation()
+	c.SetInstrumentation(inst)
+
+	get := func(ctx context.Context) error {
+		_, err := c.Analytics.GetProductMetricDimensionWithContext(ctx, "ContentDelivery", "requests", "total", "date_from=last-hour")
+		return err
+	}
+
+	// the caller starting the call leaves before the response
+	leader, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() { leaderErr <- get(leader) }()
+	<-received
+
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = get(context.Background())
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Errorf("leader error = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("caller %d error = %v, want the shared response", i, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("API calls = %d, want 1", calls.Load())
+	}
+	if executed, _ := testMetricValue(t, inst, "singleflight_requests_total", "executed"); executed != 1 {
+		t.Errorf("executed = %v, want 1", executed)
+	}
+	if coalesced, _ := testMetricValue(t, inst, "singleflight_requests_total", "coalesced"); coalesced != 2 {
+		t.Errorf("coalesced = %v, want 2", coalesced)
+	}
+}
+
+func TestInflightContext(t *testing.T) {
+	a := &AnalyticsSvc{InflightTimeout: time.Minute}
+	short, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	long, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want time.Duration
+	}{
+		{name: "no deadline", ctx: context.Background(), want: time.Minute},
+		{name: "short deadline", ctx: short, want: time.Minute},
+		{name: "long deadline", ctx: long, want: time.Hour},
+	}
+	for _, tt := range tests {
+		ctx, cancel := a.inflightContext(tt.ctx)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if got := time.Until(deadline); !ok || got > tt.want || got < tt.want-time.Second {
+			t.Errorf("%s: deadline in %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the caller canceling does not end the shared call
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, stop := a.inflightContext(parent)
+	defer stop()
+	cancel()
+	if ctx.Err() != nil {
+		t.Errorf("shared call error = %v, want nil", ctx.Err())
+	}
+}
+
+func BenchmarkGetMetric(b *testing.B) {
+	srv := testServer(testSeriesBody(time.Now().UTC().Truncate(time.Minute)))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	c := NewClientWithBaseURL(u, "user", "pass")
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ms, err := c.Analytics.GetProductMetricDimensionWithContext(ctx, "ContentDelivery", "requests", "total", "date_from=last-hour")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(ms.Datapoints("1441740010", "requests", "total")) != 60 {
+			b.Fatal("unexpected datapoints")
+		}
+	}
+}
+
+// BenchmarkGetMetricGeneric measures the former decode of the analytics
+// responses, for comparison with BenchmarkGetMetric: the body decoded as
+// interface{}, encoded again and decoded into the raw datapoints, then parsed.
+func BenchmarkGetMetricGeneric(b *testing.B) {
+	srv := testServer(testSeriesBody(time.Now().UTC().Truncate(time.Minute)))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	c := NewClientWithBaseURL(u, "user", "pass")
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, err := c.NewRequest("GET", "/analytics/products/1441740010/aggregate/metrics/requests/dimensions/total?date_from=last-hour", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var generic interface{}
+		if _, err := c.Do(req.WithContext(ctx), &generic); err != nil {
+			b.Fatal(err)
+		}
+		body, err := json.Marshal(generic)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var raw struct {
+			Products map[string]map[string]map[string][][]interface{} `json:"products"`
+		}
+		if err := json.Unmarshal(body, &raw); err != nil {
+			b.Fatal(err)
+		}
+		dps := []Datapoint{}
+		for _, r := range raw.Products["1441740010"]["requests"]["total"] {
+			ts, _ := time.ParseInLocation(DatapointLayout, r[0].(string), time.UTC)
+			v, _ := r[1].(float64)
+			dps = append(dps, Datapoint{Time: ts, Value: v})
+		}
+		if len(dps) != 60 {
+			b.Fatal("unexpected datapoints")
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package azion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reasons of the last authentication failure.
+const (
+	authReasonInvalidCredentials = "invalid_credentials"
+	authReasonForbidden          = "forbidden"
+	authReasonAPIError           = "api_error"
+	authReasonNetworkError       = "network_error"
+	authReasonCircuitOpen        = "circuit_open"
+	authReasonLocked             = "locked"
+)
+
+// ErrAuthLocked is returned when login attempts are stopped after too many
+// consecutive rejections of the credentials.
+var ErrAuthLocked = errors.New("authentication locked after consecutive rejections, credentials must change")
+
+// AuthSettings defines how login attempts back off after failures.
+type AuthSettings struct {
+	// MaxRejections is the number of consecutive 401/403 responses to POST
+	// /tokens stopping the login attempts until the credentials change.
+	// Zero disables the lockout.
+	MaxRejections int
+
+	// BackoffBase is the wait time after the first failure, doubled on each
+	// consecutive failure up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+// authState keeps the authentication failures, protecting the account from
+// being locked by repeated logins with wrong credentials.
+type authState struct {
+	// mu serializes the token lifecycle: only one login runs at a time.
+	mu sync.Mutex
+
+	settings    AuthSettings
+	failures    int
+	rejections  int
+	nextAttempt time.Time
+	lastReason  string
+
+	// lockedCreds is the digest of the credentials rejected when the
+	// lockout started.
+	locked      bool
+	lockedCreds string
+}
+
+// defaultAuthSettings return the settings used when not configured.
+func defaultAuthSettings() AuthSettings {
+	return AuthSettings{
+		MaxRejections: 5,
+		BackoffBase:   30 * time.Second,
+		BackoffMax:    30 * time.Minute,
+	}
+}
+
+// SetAuthSettings configures the login backoff and lockout.
+func (c *Client) SetAuthSettings(s AuthSettings) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+	c.auth.settings = s
+}
+
+// SetCredentials changes the credentials used to login, resetting the
+// authentication failures and the current token.
+func (c *Client) SetCredentials(email, password string) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+
+	c.Email, c.Password = email, password
+	c.Token = nil
+	c.auth.reset()
+}
+
+// authAllowed return an error when a login attempt must not be made.
+// It must be called with auth.mu held.
+func (c *Client) authAllowed() error {
+	a := &c.auth
+	if a.locked {
+		if a.lockedCreds == credentialsDigest(c.Email, c.Password) {
+			return ErrAuthLocked
+		}
+		a.reset()
+	}
+	if wait := time.Until(a.nextAttempt); wait > 0 {
+		return fmt.Errorf("login backoff for %s after %d failures: %s", wait.Round(time.Second), a.failures, a.lastReason)
+	}
+	return nil
+}
+
+// authResult records the result of a login attempt.
+// It must be called with auth.mu held.
+func (c *Client) authResult(err error) {
+	a := &c.auth
+	if err == nil {
+		a.reset()
+		c.Instrumentation.observeAuth(true, "")
+		return
+	}
+
+	a.failures++
+	a.lastReason = authFailureReason(err)
+	if a.lastReason == authReasonInvalidCredentials || a.lastReason == authReasonForbidden {
+		a.rejections++
+	} else {
+		a.rejections = 0
+	}
+
+	backoff := a.settings.BackoffMax
+	if a.failures <= 30 {
+		if b := a.settings.BackoffBase << uint(a.failures-1); b < backoff {
+			backoff = b
+		}
+	}
+	a.nextAttempt = time.Now().Add(backoff)
+
+	if a.settings.MaxRejections > 0 && a.rejections >= a.settings.MaxRejections {
+		a.locked = true
+		a.lockedCreds = credentialsDigest(c.Email, c.Password)
+		c.Instrumentation.observeAuth(false, authReasonLocked)
+		return
+	}
+	c.Instrumentation.observeAuth(false, a.lastReason)
+}
+
+// reset clears the failures. It must be called with mu held.
+func (a *authState) reset() {
+	a.failures = 0
+	a.rejections = 0
+	a.nextAttempt = time.Time{}
+	a.lastReason = ""
+	a.locked = false
+	a.lockedCreds = ""
+}
+
+// credentialsDigest return a digest identifying the credentials without
+// keeping them in clear text.
+func credentialsDigest(email, password string) string {
+	sum := sha256.Sum256([]byte(getBase64(email, password)))
+	return hex.EncodeToString(sum[:])
+}
+
+// authFailureReason return the reason of a login error.
+func authFailureReason(err error) string {
+	if errors.Is(err, ErrCircuitOpen) {
+		return authReasonCircuitOpen
+	}
+	var errResp *ErrorResponse
+	if !errors.As(err, &errResp) {
+		return authReasonNetworkError
+	}
+	switch errResp.Response.StatusCode {
+	case http.StatusUnauthorized:
+		return authReasonInvalidCredentials
+	case http.StatusForbidden:
+		return authReasonForbidden
+	default:
+		return authReasonAPIError
+	}
+}
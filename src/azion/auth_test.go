@@ -0,0 +1,108 @@
+package azion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAuthServer return an API server accepting the login of user with
+// password good, and counting the login attempts.
+func testAuthServer(logins *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logins.Add(1)
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"abc","created_at":"2030-01-01 00:00:00","expires_at":"2030-01-01 00:00:00.000"}`)
+	}))
+}
+
+func TestAuthLockout(t *testing.T) {
+	var logins atomic.Int32
+	srv := testAuthServer(&logins)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	c := NewClientWithBaseURL(u, "user", "bad")
+	c.SetAuthSettings(AuthSettings{MaxRejections: 3})
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		_, err := c.tokenValidation(ctx)
+		var errResp *ErrorResponse
+		if !errors.As(err, &errResp) || errResp.Response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("login %d error = %v, want 401", i, err)
+		}
+	}
+
+	// the credentials are not sent again while locked
+	for i := 0; i < 3; i++ {
+		if _, err := c.tokenValidation(ctx); !errors.Is(err, ErrAuthLocked) {
+			t.Fatalf("locked login error = %v, want %v", err, ErrAuthLocked)
+		}
+	}
+	if logins.Load() != 3 {
+		t.Fatalf("logins = %d, want 3", logins.Load())
+	}
+
+	// new credentials unlock the login
+	c.SetCredentials("user", "good")
+	token, err := c.tokenValidation(ctx)
+	if err != nil || token != "abc" {
+		t.Fatalf("login = %q, %v, want the token", token, err)
+	}
+	if logins.Load() != 4 {
+		t.Errorf("logins = %d, want 4", logins.Load())
+	}
+	if c.auth.failures != 0 || c.auth.rejections != 0 || c.auth.locked {
+		t.Errorf("failures = %d, rejections = %d, locked = %v, want reset after success", c.auth.failures, c.auth.rejections, c.auth.locked)
+	}
+}
+
+func TestAuthBackoff(t *testing.T) {
+	var logins atomic.Int32
+	srv := testAuthServer(&logins)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	c := NewClientWithBaseURL(u, "user", "bad")
+	c.SetAuthSettings(AuthSettings{BackoffBase: time.Second, BackoffMax: 10 * time.Second})
+
+	// the login waits for the backoff after a failure
+	if _, err := c.tokenValidation(context.Background()); err == nil {
+		t.Fatal("login with bad credentials succeeded")
+	}
+	if _, err := c.tokenValidation(context.Background()); err == nil || errors.Is(err, ErrAuthLocked) {
+		t.Fatalf("login during backoff error = %v, want backoff", err)
+	}
+	if logins.Load() != 1 {
+		t.Fatalf("logins = %d, want 1", logins.Load())
+	}
+
+	// the backoff doubles up to the maximum
+	c.auth.reset()
+	errAPI := &ErrorResponse{Response: &http.Response{StatusCode: http.StatusInternalServerError}}
+	for i, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		c.authResult(errAPI)
+		got := time.Until(c.auth.nextAttempt)
+		if want *= time.Second; got > want || got < want-time.Second {
+			t.Errorf("failure %d: backoff = %v, want %v", i+1, got, want)
+		}
+	}
+
+	// a success resets the failures
+	c.authResult(nil)
+	if c.auth.failures != 0 || !c.auth.nextAttempt.IsZero() || c.auth.lastReason != "" {
+		t.Errorf("failures = %d, next attempt = %v, reason = %q, want reset after success", c.auth.failures, c.auth.nextAttempt, c.auth.lastReason)
+	}
+}
@@ -0,0 +1,178 @@
+package azion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Endpoint classes sharing one circuit breaker.
+const (
+	EndpointClassAuth      = "auth"
+	EndpointClassAnalytics = "analytics"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+// Circuit breaker states, the values are exposed on the state metric.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// ErrCircuitOpen is returned when a request is rejected by an open circuit.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrTimeout is the cause of the context deadline of the callers bounding a
+// request by the time the API is expected to answer in, set with
+// context.WithTimeoutCause. A request exceeding it is a breaker failure,
+// unlike the requests canceled or exceeding another deadline of the caller.
+var ErrTimeout = errors.New("azion API request timeout")
+
+// BreakerSettings defines when a circuit breaker opens and for how long.
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit.
+	FailureThreshold int
+
+	// OpenTimeout is the time the circuit stays open before allowing one
+	// probe request (half-open).
+	OpenTimeout time.Duration
+}
+
+// circuitBreaker fails fast the requests of an endpoint class while the
+// API is failing.
+type circuitBreaker struct {
+	class    string
+	settings BreakerSettings
+	onChange func(class string, state BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// defaultBreakerSettings return the settings used by each endpoint class when
+// not configured.
+func defaultBreakerSettings(class string) BreakerSettings {
+	if class == EndpointClassAuth {
+		return BreakerSettings{FailureThreshold: 3, OpenTimeout: 5 * time.Minute}
+	}
+	return BreakerSettings{FailureThreshold: 5, OpenTimeout: time.Minute}
+}
+
+// endpointClass return the endpoint class of an API path.
+func endpointClass(path string) string {
+	if endpointTemplate(path) == "/tokens" {
+		return EndpointClassAuth
+	}
+	return EndpointClassAnalytics
+}
+
+// String return the state name.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// allow return an error when the request must not be sent.
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.settings.OpenTimeout {
+			return fmt.Errorf("%s: %w", cb.class, ErrCircuitOpen)
+		}
+		cb.setState(BreakerHalfOpen)
+		cb.probing = true
+		return nil
+	case BreakerHalfOpen:
+		// only one probe request is allowed while half-open
+		if cb.probing {
+			return fmt.Errorf("%s: %w", cb.class, ErrCircuitOpen)
+		}
+		cb.probing = true
+		return nil
+	}
+	return nil
+}
+
+// record updates the circuit with the result of an allowed request.
+func (cb *circuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+	if success {
+		cb.failures = 0
+		cb.setState(BreakerClosed)
+		return
+	}
+
+	cb.failures++
+	if cb.state == BreakerHalfOpen || cb.failures >= cb.settings.FailureThreshold {
+		cb.openedAt = time.Now()
+		cb.setState(BreakerOpen)
+	}
+}
+
+// release ends an allowed request without result, such as a request canceled
+// by the caller, neither a failure nor a success: the counted failures and the
+// state are kept, and a half-open circuit allows a new probe.
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+}
+
+// currentState return the circuit state.
+func (cb *circuitBreaker) currentState() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// setState changes the state and notifies it. It must be called with mu held.
+func (cb *circuitBreaker) setState(s BreakerState) {
+	if cb.state == s {
+		return
+	}
+	cb.state = s
+	if cb.onChange != nil {
+		cb.onChange(cb.class, s)
+	}
+}
+
+// breakerCanceled return true when the request failed because the context
+// ctx of the caller was canceled or exceeded its deadline, such as a scrape
+// timeout, a result telling nothing about the API. A deadline caused by
+// ErrTimeout is a failure.
+func breakerCanceled(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() == nil {
+		return false
+	}
+	return !errors.Is(context.Cause(ctx), ErrTimeout)
+}
+
+// breakerFailure return true when the request result means the API is
+// unavailable: transport errors, 429 and 5xx responses.
+func breakerFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
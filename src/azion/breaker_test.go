@@ -0,0 +1,96 @@
+package azion
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreakerCanceled(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	timedOut, cancel := context.WithTimeoutCause(context.Background(), -time.Second, ErrTimeout)
+	defer cancel()
+	child, cancel := context.WithCancel(timedOut)
+	defer cancel()
+
+	errTransport := errors.New("connection reset")
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "success", ctx: context.Background()},
+		{name: "transport error", ctx: context.Background(), err: errTransport},
+		{name: "canceled", ctx: canceled, err: context.Canceled, want: true},
+		{name: "caller deadline", ctx: expired, err: context.DeadlineExceeded, want: true},
+		{name: "request timeout", ctx: timedOut, err: context.DeadlineExceeded},
+		{name: "request timeout of the parent", ctx: child, err: context.DeadlineExceeded},
+		{name: "done without error", ctx: canceled},
+	}
+	for _, tt := range tests {
+		if got := breakerCanceled(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: breakerCanceled() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	cb := &circuitBreaker{settings: BreakerSettings{FailureThreshold: 2, OpenTimeout: time.Minute}}
+
+	// a released request keeps the counted failures
+	cb.record(false)
+	if err := cb.allow(); err != nil {
+		t.Fatal(err)
+	}
+	cb.release()
+	cb.record(false)
+	if cb.currentState() != BreakerOpen {
+		t.Fatalf("state = %s, want open", cb.currentState())
+	}
+
+	// a released probe keeps the circuit half-open and allows a new probe
+	cb.openedAt = time.Now().Add(-2 * time.Minute)
+	if err := cb.allow(); err != nil {
+		t.Fatal(err)
+	}
+	cb.release()
+	if cb.currentState() != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", cb.currentState())
+	}
+	if err := cb.allow(); err != nil {
+		t.Fatalf("probe after release: %v", err)
+	}
+	if err := cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe error = %v, want %v", err, ErrCircuitOpen)
+	}
+	cb.record(true)
+	if cb.currentState() != BreakerClosed {
+		t.Fatalf("state = %s, want closed", cb.currentState())
+	}
+}
+
+func TestBreakerFailure(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusNotFound},
+		{status: http.StatusTooManyRequests, want: true},
+		{status: http.StatusBadGateway, want: true},
+	}
+	for _, tt := range tests {
+		if got := breakerFailure(&http.Response{StatusCode: tt.status}, nil); got != tt.want {
+			t.Errorf("breakerFailure(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+	if !breakerFailure(nil, errors.New("connection reset")) {
+		t.Error("breakerFailure(transport error) = false, want true")
+	}
+}
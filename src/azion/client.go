@@ -2,6 +2,7 @@ package azion
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,11 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -20,8 +26,11 @@ const (
 	defaultBaseURL   = "https://api.azionapi.net/"
 	userAgent        = "azion-go-sdk/" + libraryVersion
 	defaultMediaType = "application/json; version=" + apiVersion
+	tracerName       = "github.com/mtulio/azion-exporter/src/azion"
 )
 
+var tracer = otel.Tracer(tracerName)
+
 // A Client manages communication with the API.
 type Client struct {
 	// HTTP client used to communicate with the API
@@ -47,6 +56,18 @@ type Client struct {
 	// User agent used when communicating with the API.
 	UserAgent string
 
+	// Instrumentation records the metrics of the requests sent to the API.
+	Instrumentation *Instrumentation
+
+	// auth keeps the login failures and serializes the token renewal.
+	auth authState
+
+	// rate keeps the API quota reported by the rate-limit headers.
+	rate rateLimiter
+
+	// breakers fail fast the requests by endpoint class while the API is down.
+	breakers map[string]*circuitBreaker
+
 	// Services used to manipulate API entities.
 	Analytics *AnalyticsSvc
 	// CloudSecurity   *CloudSecurity
@@ -88,9 +109,17 @@ func NewClientWithBaseURL(baseURL *url.URL, email, password string) *Client {
 		UserAgent: userAgent,
 	}
 
+	c.auth.settings = defaultAuthSettings()
+	c.rate.settings = defaultRateLimitSettings()
+	c.breakers = make(map[string]*circuitBreaker)
+	for _, class := range []string{EndpointClassAuth, EndpointClassAnalytics} {
+		c.SetBreakerSettings(class, defaultBreakerSettings(class))
+	}
+
 	c.Analytics = &AnalyticsSvc{
-		client:  c,
-		BaseURI: "/analytics",
+		client:          c,
+		BaseURI:         "/analytics",
+		InflightTimeout: defaultInflightTimeout,
 	}
 	// c.CloudSecurity = &CloudSecurityService{client: c}
 	// c.ContentDelivery = &ContentDeliveryService{client: c}
@@ -99,6 +128,56 @@ func NewClientWithBaseURL(baseURL *url.URL, email, password string) *Client {
 	return c
 }
 
+// SetInstrumentation enables the metrics of the requests sent to the API,
+// wrapping the current HTTP transport with the instrumented RoundTripper.
+func (c *Client) SetInstrumentation(i *Instrumentation) {
+	c.Instrumentation = i
+	c.client = &http.Client{
+		Transport: i.RoundTripper(c.client.Transport),
+		Timeout:   c.client.Timeout,
+	}
+	for class, cb := range c.breakers {
+		i.observeBreakerState(class, cb.currentState())
+	}
+}
+
+// SetBreakerSettings configures the circuit breaker of an endpoint class,
+// EndpointClassAuth or EndpointClassAnalytics. The circuit is reset to closed.
+func (c *Client) SetBreakerSettings(class string, s BreakerSettings) {
+	c.breakers[class] = &circuitBreaker{
+		class:    class,
+		settings: s,
+		onChange: func(class string, state BreakerState) {
+			c.Instrumentation.observeBreakerState(class, state)
+		},
+	}
+	c.Instrumentation.observeBreakerState(class, BreakerClosed)
+}
+
+// send sends the request through the circuit breaker of its endpoint class,
+// slowing down when the API quota is low.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if err := c.rateLimitWait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	cb := c.breakers[endpointClass(req.URL.Path)]
+	if err := cb.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if breakerCanceled(req.Context(), err) {
+		cb.release()
+	} else {
+		cb.record(!breakerFailure(resp, err))
+	}
+	if err == nil {
+		c.rateLimitUpdate(resp)
+	}
+	return resp, err
+}
+
 // getTokenBase64 return Base64 string from string arguments.
 func getBase64(e, p string) string {
 	data := []byte(e + ":" + p)
@@ -126,15 +205,16 @@ func timeParser(strDate string) (time.Time, error) {
 // tokenRequest make one http request to renew an Token.
 //
 // API doc: https://www.azion.com.br/developers/api-v2/authentication/
-func (c *Client) tokenRequest(v interface{}) error {
+func (c *Client) tokenRequest(ctx context.Context, v interface{}) error {
 	req, err := c.NewRequest("POST", "/tokens", nil)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	req.SetBasicAuth(c.Email, c.Password)
 
-	resp, err := c.client.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
 		return err
 	}
@@ -149,17 +229,19 @@ func (c *Client) tokenRequest(v interface{}) error {
 		if w, ok := v.(io.Writer); ok {
 			_, err = io.Copy(w, resp.Body)
 		} else {
-			err = json.NewDecoder(resp.Body).Decode(v)
+			err = decodeJSON(resp.Body, v)
 		}
 	}
 
-	return nil
+	return err
 }
 
 // tokenRenew renew an Token and return error if it fails.
 //
 // API doc: https://www.azion.com.br/developers/api-v2/authentication/
-func (c *Client) tokenRenew() error {
+func (c *Client) tokenRenew(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "azion.Client.tokenRenew")
+	defer span.End()
 
 	type reqToken struct {
 		Token     string `json:"token"`
@@ -168,8 +250,10 @@ func (c *Client) tokenRenew() error {
 	}
 	tokenResp := new(reqToken)
 
-	err := c.tokenRequest(tokenResp)
+	err := c.tokenRequest(ctx, tokenResp)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
@@ -189,20 +273,39 @@ func (c *Client) tokenRenew() error {
 	return nil
 }
 
-// tokenValidation return check if token is expired and renew it.
+// tokenValidation check if token is expired and renew it, returning the
+// valid token. Login attempts are skipped while backing off after failures.
 //
 // API doc: https://www.azion.com.br/developers/api-v2/authentication/
-func (c *Client) tokenValidation() error {
-	if c.Token == nil {
-		return c.tokenRenew()
+func (c *Client) tokenValidation(ctx context.Context) (string, error) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+
+	// check if token is valid
+	if c.Token != nil && !time.Now().After(c.Token.ExpirationDate) {
+		return c.Token.Token, nil
+	}
+
+	if err := c.authAllowed(); err != nil {
+		return "", err
 	}
-	// check if token is not valid
-	tExpired := time.Now().After(c.Token.ExpirationDate)
-	if tExpired {
-		return c.tokenRenew()
+	err := c.tokenRenew(ctx)
+	c.authResult(err)
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	return c.Token.Token, nil
+}
+
+// tokenInvalidate discards the token when it was rejected by the API.
+func (c *Client) tokenInvalidate(token string) {
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+
+	if c.Token != nil && c.Token.Token == token {
+		c.Token = nil
+	}
 }
 
 // NewRequest creates an API request. A relative URL can be provided in urlStr,
@@ -248,37 +351,75 @@ func (c *Client) NewRequest(method, urlStr string, body interface{}) (*http.Requ
 // error if an API error has occurred.  If v implements the io.Writer
 // interface, the raw response body will be written to v, without attempting to
 // first decode it.
+//
+// When the API rejects a token considered valid by the client, the token is
+// renewed and the request is retried once.
 func (c *Client) Do(req *http.Request, v interface{}) (*http.Response, error) {
-
-	// req.SetBasicAuth(c.Email, c.Password)
-	errT := c.tokenValidation()
-	if errT != nil {
-		return nil, errT
+	ctx, span := tracer.Start(req.Context(), "azion.Client.Do",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.route", endpointTemplate(req.URL.Path)),
+		),
+	)
+	defer span.End()
+	req = req.WithContext(ctx)
+
+	resp, retries, err := c.doAuthorized(req)
+	span.SetAttributes(attribute.Int("azion.retries", retries))
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 	}
-	req.Header.Set("Authorization", "Token "+c.Token.Token)
-
-	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
 	}
 	defer resp.Body.Close()
 
 	err = CheckResponse(resp)
-	if err != nil {
-		return resp, err
-	}
-
-	if v != nil && resp.ContentLength != 0 {
+	if err == nil && v != nil && resp.ContentLength != 0 {
 		if w, ok := v.(io.Writer); ok {
 			_, err = io.Copy(w, resp.Body)
 		} else {
-			err = json.NewDecoder(resp.Body).Decode(v)
+			err = decodeJSON(resp.Body, v)
 		}
 	}
 
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
 	return resp, err
 }
 
+// doAuthorized sends the request with the authorization token, renewing
+// the token and retrying once when the API answers 401. It returns the
+// response and the number of retries made.
+func (c *Client) doAuthorized(req *http.Request) (*http.Response, int, error) {
+	retries := 0
+	for {
+		token, errT := c.tokenValidation(req.Context())
+		if errT != nil {
+			return nil, retries, errT
+		}
+		req.Header.Set("Authorization", "Token "+token)
+
+		resp, err := c.send(req)
+		if err != nil {
+			return nil, retries, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || retries > 0 || req.Body != nil {
+			return resp, retries, nil
+		}
+
+		resp.Body.Close()
+		c.tokenInvalidate(token)
+		retries++
+	}
+}
+
 // ErrorResponse reports an error caused by an API request.
 // ErrorResponse implements the Error interface.
 type ErrorResponse struct {
@@ -289,6 +430,13 @@ type ErrorResponse struct {
 	Errors ErrorResponseMessages `json:"errors"`
 }
 
+// Error implements the Error interface.
+func (r *ErrorResponse) Error() string {
+	return fmt.Sprintf("%v %v: %d %+v",
+		r.Response.Request.Method, r.Response.Request.URL.Path,
+		r.Response.StatusCode, r.Errors)
+}
+
 // ErrorResponseMessages contains error messages returned from the Azion API.
 type ErrorResponseMessages struct {
 	Params  map[string]interface{} `json:"params,omitempty"`
@@ -311,5 +459,5 @@ func CheckResponse(r *http.Response) error {
 		json.Unmarshal(data, errorResponse)
 	}
 
-	return nil
+	return errorResponse
 }
@@ -0,0 +1,37 @@
+package azion
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a response buffer is released
+// instead of returned to the pool, not to keep an unusual large response.
+const maxPooledBuffer = 4 << 20
+
+// bufferPool keeps the buffers the response bodies are read into.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// decodeJSON decodes the JSON body r into v, reading it into a pooled buffer.
+// A json.Decoder buffers the whole value before decoding it as well, in a new
+// buffer grown on each response.
+func decodeJSON(r io.Reader, v interface{}) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), v)
+}
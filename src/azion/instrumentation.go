@@ -0,0 +1,335 @@
+package azion
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// instrumentationNamespace is the namespace used by the client metrics.
+	instrumentationNamespace = "azion_exporter"
+	instrumentationSubsystem = "api"
+)
+
+// endpointTemplates maps the API paths to the endpoint template used as label,
+// keeping the cardinality bounded to the known API endpoints.
+var endpointTemplates = []struct {
+	re       *regexp.Regexp
+	template string
+}{
+	{regexp.MustCompile(`^/tokens/?$`), "/tokens"},
+	{regexp.MustCompile(`^/analytics/metadata/?$`), "/analytics/metadata"},
+	{
+		regexp.MustCompile(`^/analytics/products/[^/]+/aggregate/metrics/[^/]+/dimensions/[^/]+/?$`),
+		"/analytics/products/{product}/aggregate/metrics/{metric}/dimensions/{dimension}",
+	},
+	{
+		regexp.MustCompile(`^/analytics/products/[^/]+/aggregate/metrics/[^/]+/?$`),
+		"/analytics/products/{product}/aggregate/metrics/{metric}",
+	},
+}
+
+// Instrumentation keeps the Prometheus metrics of the API calls made by the
+// Client. Instrumentation implements the prometheus.Collector interface.
+type Instrumentation struct {
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	responseSize  *prometheus.HistogramVec
+	tokenRenewals prometheus.Counter
+	authFailures  prometheus.Counter
+	singleflight  *prometheus.CounterVec
+	breakerState  *prometheus.GaugeVec
+	authUp        *prometheus.GaugeVec
+	rateLimit     prometheus.Gauge
+	rateRemaining prometheus.Gauge
+	rateReset     prometheus.Gauge
+	rateDelay     prometheus.Counter
+}
+
+// instrumentedTransport is an http.RoundTripper recording the Instrumentation
+// metrics for each request.
+type instrumentedTransport struct {
+	next http.RoundTripper
+	inst *Instrumentation
+}
+
+// NewInstrumentation returns the Instrumentation with all metrics initialized.
+func NewInstrumentation() *Instrumentation {
+	return &Instrumentation{
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "requests_total",
+				Help:      "Total of requests made to Azion API by endpoint, method and status code.",
+			},
+			[]string{"endpoint", "method", "code"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "request_duration_seconds",
+				Help:      "Latency of requests made to Azion API by endpoint and method.",
+				Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+			},
+			[]string{"endpoint", "method"},
+		),
+		responseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "response_size_bytes",
+				Help:      "Size of responses returned by Azion API by endpoint.",
+				Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+			},
+			[]string{"endpoint"},
+		),
+		tokenRenewals: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "token_renewals_total",
+				Help:      "Total of authorization tokens successfully renewed.",
+			},
+		),
+		authFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "auth_failures_total",
+				Help:      "Total of requests rejected by Azion API with 401 or 403.",
+			},
+		),
+		singleflight: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "singleflight_requests_total",
+				Help:      "Total of analytics queries executed or coalesced with an identical in-flight query.",
+			},
+			[]string{"result"},
+		),
+		breakerState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "circuit_breaker_state",
+				Help:      "State of the circuit breaker by endpoint class: 0 closed, 1 open, 2 half-open.",
+			},
+			[]string{"class"},
+		),
+		authUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: instrumentationNamespace,
+				Name:      "auth_up",
+				Help:      "Whether the last login to Azion API succeeded, with the reason of the last failure.",
+			},
+			[]string{"reason"},
+		),
+		rateLimit: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "rate_limit_limit",
+				Help:      "Requests allowed in the window reported by the API rate-limit headers.",
+			},
+		),
+		rateRemaining: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "rate_limit_remaining",
+				Help:      "Requests left in the window reported by the API rate-limit headers.",
+			},
+		),
+		rateReset: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "rate_limit_reset_timestamp_seconds",
+				Help:      "Time the rate-limit window resets, reported by the API rate-limit headers.",
+			},
+		),
+		rateDelay: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: instrumentationNamespace,
+				Subsystem: instrumentationSubsystem,
+				Name:      "rate_limit_delay_seconds_total",
+				Help:      "Total of time the requests waited for the API quota.",
+			},
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (i *Instrumentation) Describe(ch chan<- *prometheus.Desc) {
+	i.requests.Describe(ch)
+	i.duration.Describe(ch)
+	i.responseSize.Describe(ch)
+	i.tokenRenewals.Describe(ch)
+	i.authFailures.Describe(ch)
+	i.singleflight.Describe(ch)
+	i.breakerState.Describe(ch)
+	i.authUp.Describe(ch)
+	i.rateLimit.Describe(ch)
+	i.rateRemaining.Describe(ch)
+	i.rateReset.Describe(ch)
+	i.rateDelay.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (i *Instrumentation) Collect(ch chan<- prometheus.Metric) {
+	i.requests.Collect(ch)
+	i.duration.Collect(ch)
+	i.responseSize.Collect(ch)
+	i.tokenRenewals.Collect(ch)
+	i.authFailures.Collect(ch)
+	i.singleflight.Collect(ch)
+	i.breakerState.Collect(ch)
+	i.authUp.Collect(ch)
+	i.rateLimit.Collect(ch)
+	i.rateRemaining.Collect(ch)
+	i.rateReset.Collect(ch)
+	i.rateDelay.Collect(ch)
+}
+
+// observeSingleflight records whether a query was executed or coalesced.
+func (i *Instrumentation) observeSingleflight(executed bool) {
+	if i == nil {
+		return
+	}
+	if executed {
+		i.singleflight.WithLabelValues("executed").Inc()
+		return
+	}
+	i.singleflight.WithLabelValues("coalesced").Inc()
+}
+
+// observeBreakerState records the circuit breaker state of an endpoint class.
+func (i *Instrumentation) observeBreakerState(class string, state BreakerState) {
+	if i == nil {
+		return
+	}
+	i.breakerState.WithLabelValues(class).Set(float64(state))
+}
+
+// observeAuth records the result of the last login, keeping one series with
+// the reason of the failure.
+func (i *Instrumentation) observeAuth(up bool, reason string) {
+	if i == nil {
+		return
+	}
+	i.authUp.Reset()
+	if up {
+		i.authUp.WithLabelValues(reason).Set(1)
+		return
+	}
+	i.authUp.WithLabelValues(reason).Set(0)
+}
+
+// observeRateLimit records the quota reported by the API.
+func (i *Instrumentation) observeRateLimit(rl RateLimit) {
+	if i == nil {
+		return
+	}
+	i.rateLimit.Set(float64(rl.Limit))
+	i.rateRemaining.Set(float64(rl.Remaining))
+	if !rl.Reset.IsZero() {
+		i.rateReset.Set(float64(rl.Reset.Unix()))
+	}
+}
+
+// observeRateLimitDelay records the time a request waited for the quota.
+func (i *Instrumentation) observeRateLimitDelay(d time.Duration) {
+	if i == nil {
+		return
+	}
+	i.rateDelay.Add(d.Seconds())
+}
+
+// RoundTripper returns an http.RoundTripper recording the metrics of each
+// request sent through next. When next is nil http.DefaultTransport is used.
+func (i *Instrumentation) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &instrumentedTransport{next: next, inst: i}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := endpointTemplate(req.URL.Path)
+	begin := time.Now()
+
+	resp, err := t.next.RoundTrip(req)
+	t.inst.duration.WithLabelValues(endpoint, req.Method).Observe(time.Since(begin).Seconds())
+	if err != nil {
+		t.inst.requests.WithLabelValues(endpoint, req.Method, "error").Inc()
+		return resp, err
+	}
+
+	t.inst.requests.WithLabelValues(endpoint, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.Body != nil {
+		resp.Body = &countingBody{
+			ReadCloser: resp.Body,
+			observe:    t.inst.responseSize.WithLabelValues(endpoint).Observe,
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		t.inst.authFailures.Inc()
+	case endpoint == "/tokens" && 200 <= resp.StatusCode && resp.StatusCode <= 299:
+		t.inst.tokenRenewals.Inc()
+	}
+
+	return resp, nil
+}
+
+// countingBody is an io.ReadCloser counting the bytes read from the response
+// body, as the Content-Length is unknown on compressed and chunked responses.
+// The size is observed once, at the end of the body or when it is closed.
+type countingBody struct {
+	io.ReadCloser
+	n       int64
+	once    sync.Once
+	observe func(float64)
+}
+
+// Read implements the io.Reader interface.
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (b *countingBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+// done observes the bytes read.
+func (b *countingBody) done() {
+	b.once.Do(func() { b.observe(float64(b.n)) })
+}
+
+// endpointTemplate return the endpoint template of an API path.
+func endpointTemplate(path string) string {
+	for _, e := range endpointTemplates {
+		if e.re.MatchString(path) {
+			return e.template
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,90 @@
+package azion
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testMetricValue return the value of the counter, gauge or the sample sum of
+// the histogram gathered from inst with the name and label value, and the
+// histogram sample count.
+func testMetricValue(t *testing.T, inst *Instrumentation, name, label string) (float64, uint64) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(inst)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != instrumentationNamespace+"_"+instrumentationSubsystem+"_"+name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if len(m.GetLabel()) > 0 && m.GetLabel()[0].GetValue() != label {
+				continue
+			}
+			switch {
+			case m.GetHistogram() != nil:
+				return m.GetHistogram().GetSampleSum(), m.GetHistogram().GetSampleCount()
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue(), 0
+			}
+			return m.GetGauge().GetValue(), 0
+		}
+	}
+	return 0, 0
+}
+
+func TestInstrumentationResponseSize(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// flushing before the end sends the body without Content-Length
+			io.WriteString(w, body[:500])
+			w.(http.Flusher).Flush()
+		} else {
+			io.WriteString(w, body[:500])
+		}
+		io.WriteString(w, body[500:])
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path  string
+		close bool
+	}{
+		{path: "/length"},
+		{path: "/chunked"},
+		{path: "/closed", close: true},
+	}
+	for _, tt := range tests {
+		inst := NewInstrumentation()
+		client := &http.Client{Transport: inst.RoundTripper(nil)}
+		resp, err := client.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.path == "/chunked" && resp.ContentLength >= 0 {
+			t.Fatalf("%s: content length = %d, want unknown", tt.path, resp.ContentLength)
+		}
+		want := float64(len(body))
+		if tt.close {
+			io.ReadFull(resp.Body, make([]byte, 100))
+			want = 100
+		} else {
+			io.Copy(io.Discard, resp.Body)
+		}
+		resp.Body.Close()
+
+		sum, count := testMetricValue(t, inst, "response_size_bytes", "other")
+		if count != 1 || sum != want {
+			t.Errorf("%s: response size count = %d, sum = %v, want 1 observation of %v", tt.path, count, sum, want)
+		}
+	}
+}
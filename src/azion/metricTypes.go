@@ -1,251 +1,103 @@
 package azion
 
-type MetricRespDTTotal struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"total"`
-			} `json:"data_transferred"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespDTSaved struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"saved"`
-			} `json:"data_transferred"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespDTMissed struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"missed"`
-			} `json:"data_transferred"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespBWTotal struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"total"`
-			} `json:"bandwidth"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespBWSaved struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"saved"`
-			} `json:"bandwidth"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespBWMissed struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"missed"`
-			} `json:"bandwidth"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespReqTotal struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"total"`
-			} `json:"requests"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespReqSaved struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"saved"`
-			} `json:"requests"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespReqMissed struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"missed"`
-			} `json:"requests"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode2xx struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"2xx"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode200 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"200"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode204 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"204"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode206 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"206"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode3xx struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"3xx"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode301 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"301"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode302 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"302"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode304 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"304"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode4xx struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"4xx"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode400 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"400"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode403 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"403"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode404 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"404"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode5xx struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"5xx"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode500 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"500"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode502 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"502"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
-}
-
-type MetricRespSCode503 struct {
-	Products struct {
-		Prod1441740010 struct {
-			Metric struct {
-				Dimension [][]interface{} `json:"503"`
-			} `json:"status_code"`
-		} `json:"1441740010"`
-	} `json:"products"`
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// DatapointLayout is the time layout of the datapoints, in UTC.
+const DatapointLayout = "2006-01-02 15:04:05"
+
+// MetricSeries is the metric response payload of the analytics aggregate
+// endpoints, indexed by product ID, metric and dimension.
+type MetricSeries struct {
+	Products map[string]map[string]map[string][]Datapoint `json:"products"`
+}
+
+// Datapoint is a datapoint of a series, decoded from the pair of timestamp
+// and value [ "2006-01-02 15:04:05", 10 ]. Null is set when the value is null
+// or invalid, and Time is zero when the timestamp is invalid.
+type Datapoint struct {
+	Time  time.Time
+	Value float64
+	Null  bool
+}
+
+// Datapoints return the datapoints of the product ID, metric and dimension,
+// or nil when the series is not present.
+func (ms *MetricSeries) Datapoints(productID, metric, dimension string) []Datapoint {
+	return ms.Products[productID][metric][dimension]
+}
+
+// Dimensions return the datapoints of each dimension of the product ID and
+// metric, or nil when the metric is not present.
+func (ms *MetricSeries) Dimensions(productID, metric string) map[string][]Datapoint {
+	return ms.Products[productID][metric]
+}
+
+// UnmarshalJSON decodes the datapoint pair without the intermediate values
+// of a generic decode, the series having thousands of datapoints.
+func (dp *Datapoint) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) < 2 || b[0] != '[' || b[len(b)-1] != ']' {
+		return fmt.Errorf("azion: invalid datapoint %q", b)
+	}
+	b = b[1 : len(b)-1]
+
+	// the timestamp is the first element, a string or a number
+	var ts []byte
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		end := bytes.IndexByte(b[1:], '"')
+		if end < 0 {
+			return fmt.Errorf("azion: invalid datapoint timestamp %q", b)
+		}
+		ts, b = b[1:end+1], b[end+2:]
+	} else {
+		end := bytes.IndexByte(b, ',')
+		if end < 0 {
+			end = len(b)
+		}
+		ts, b = bytes.TrimSpace(b[:end]), b[end:]
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != ',' {
+		return fmt.Errorf("azion: invalid datapoint, expected timestamp and value")
+	}
+	b = b[1:]
+	if end := bytes.IndexByte(b, ','); end >= 0 {
+		b = b[:end]
+	}
+
+	*dp = Datapoint{Time: datapointTime(ts)}
+	value, err := strconv.ParseFloat(string(bytes.Trim(bytes.TrimSpace(b), `"`)), 64)
+	if err != nil {
+		dp.Null = true
+		return nil
+	}
+	dp.Value = value
+	return nil
+}
+
+// datapointTime return the time of a datapoint timestamp, formatted as
+// DatapointLayout or as epoch seconds or milliseconds, or the zero time.
+func datapointTime(ts []byte) time.Time {
+	s := string(bytes.TrimSpace(ts))
+	for _, layout := range []string{DatapointLayout, "2006-01-02T15:04:05", time.RFC3339} {
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err == nil {
+			return t
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > 1e12 {
+			return time.UnixMilli(int64(f)).UTC()
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	}
+	return time.Time{}
 }
@@ -0,0 +1,60 @@
+package azion
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDatapointUnmarshalJSON(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    Datapoint
+		wantErr bool
+	}{
+		{in: `["2024-01-02 03:04:00", 10]`, want: Datapoint{Time: ts, Value: 10}},
+		{in: `[ "2024-01-02 03:04:00" , 1.5e3 ]`, want: Datapoint{Time: ts, Value: 1500}},
+		{in: `["2024-01-02T03:04:00", "7.5"]`, want: Datapoint{Time: ts, Value: 7.5}},
+		{in: `["2024-01-02T03:04:00Z", 0]`, want: Datapoint{Time: ts, Value: 0}},
+		{in: `[1704164640, 3]`, want: Datapoint{Time: ts, Value: 3}},
+		{in: `[1704164640000, 3]`, want: Datapoint{Time: ts, Value: 3}},
+		{in: `["2024-01-02 03:04:00", null]`, want: Datapoint{Time: ts, Null: true}},
+		{in: `["2024-01-02 03:04:00", "n/a"]`, want: Datapoint{Time: ts, Null: true}},
+		{in: `["yesterday", 4]`, want: Datapoint{Value: 4}},
+		{in: `["2024-01-02 03:04:00", 4, "extra"]`, want: Datapoint{Time: ts, Value: 4}},
+
+		{in: `{"time": 1}`, wantErr: true},
+		{in: `["2024-01-02 03:04:00"]`, wantErr: true},
+		{in: `["2024-01-02 03:04:00]`, wantErr: true},
+		{in: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var dp Datapoint
+		err := json.Unmarshal([]byte(tt.in), &dp)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !dp.Time.Equal(tt.want.Time) || dp.Value != tt.want.Value || dp.Null != tt.want.Null {
+			t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.in, dp, tt.want)
+		}
+	}
+}
+
+func BenchmarkDatapointUnmarshal(b *testing.B) {
+	body := []byte(testSeriesBody(time.Now().UTC().Truncate(time.Minute)))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var ms MetricSeries
+		if err := json.Unmarshal(body, &ms); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
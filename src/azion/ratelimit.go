@@ -0,0 +1,194 @@
+package azion
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is the API quota reported by the rate-limit response headers.
+type RateLimit struct {
+	// Limit is the number of requests allowed in the window.
+	Limit int
+
+	// Remaining is the number of requests left in the window.
+	Remaining int
+
+	// Reset is the time the window resets, zero when the API reports no reset.
+	Reset time.Time
+
+	// UpdatedAt is the time of the response reporting the quota, zero when the
+	// API has not reported any quota.
+	UpdatedAt time.Time
+}
+
+// RateLimitSettings defines how the client slows down when the quota drops.
+type RateLimitSettings struct {
+	// Threshold is the fraction of the remaining quota starting the slowdown.
+	// The requests are spread until the window resets. Zero disables it.
+	Threshold float64
+
+	// MaxDelay is the maximum time a request waits for the quota.
+	MaxDelay time.Duration
+
+	// Window is the quota window assumed when the API reports the quota
+	// without its reset, starting at the response reporting it.
+	Window time.Duration
+}
+
+// rateLimiter keeps the quota reported by the API.
+type rateLimiter struct {
+	mu       sync.Mutex
+	settings RateLimitSettings
+	last     RateLimit
+}
+
+// defaultRateLimitSettings return the settings used when not configured.
+func defaultRateLimitSettings() RateLimitSettings {
+	return RateLimitSettings{
+		Threshold: 0.2,
+		MaxDelay:  30 * time.Second,
+		Window:    time.Minute,
+	}
+}
+
+// SetRateLimitSettings configures the slowdown when the quota drops.
+func (c *Client) SetRateLimitSettings(s RateLimitSettings) {
+	c.rate.mu.Lock()
+	defer c.rate.mu.Unlock()
+	c.rate.settings = s
+}
+
+// RateLimit return the last quota reported by the API.
+func (c *Client) RateLimit() RateLimit {
+	c.rate.mu.Lock()
+	defer c.rate.mu.Unlock()
+	return c.rate.last
+}
+
+// rateLimitWait blocks the request while the quota is low, returning an
+// error when ctx is done first.
+func (c *Client) rateLimitWait(ctx context.Context) error {
+	delay := c.rate.delay(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	c.Instrumentation.observeRateLimitDelay(delay)
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// rateLimitUpdate records the quota reported by the response headers.
+func (c *Client) rateLimitUpdate(resp *http.Response) {
+	rl, ok := parseRateLimit(resp.Header, time.Now())
+	if !ok {
+		return
+	}
+	c.rate.mu.Lock()
+	c.rate.last = rl
+	c.rate.mu.Unlock()
+	c.Instrumentation.observeRateLimit(rl)
+}
+
+// delay return the time the next request must wait: nothing while the
+// quota is above the threshold, spreading the remaining requests until the
+// reset below it, and up to the reset when the quota is exhausted. Without a
+// reset reported, the window ends the Window after the response.
+func (r *rateLimiter) delay(now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rl := r.last
+	reset := rl.Reset
+	if reset.IsZero() {
+		reset = rl.UpdatedAt.Add(r.settings.Window)
+	}
+	untilReset := reset.Sub(now)
+	if rl.UpdatedAt.IsZero() || rl.Limit <= 0 || untilReset <= 0 {
+		return 0
+	}
+	if float64(rl.Remaining) >= r.settings.Threshold*float64(rl.Limit) {
+		return 0
+	}
+
+	d := untilReset
+	if rl.Remaining > 0 {
+		d = untilReset / time.Duration(rl.Remaining+1)
+		// the request consumes the quota until the API reports it again
+		r.last.Remaining--
+	}
+	if d > r.settings.MaxDelay {
+		d = r.settings.MaxDelay
+	}
+	return d
+}
+
+// parseRateLimit return the quota from the rate-limit headers, supporting
+// X-RateLimit-* and RateLimit-* headers and Retry-After. Reset may be an
+// epoch timestamp or a number of seconds, and Retry-After a number of seconds
+// or an HTTP date.
+func parseRateLimit(h http.Header, now time.Time) (RateLimit, bool) {
+	rl := RateLimit{UpdatedAt: now}
+
+	limit, okLimit := headerInt(h, "X-RateLimit-Limit", "RateLimit-Limit")
+	remaining, okRemaining := headerInt(h, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	reset, okReset := headerInt(h, "X-RateLimit-Reset", "RateLimit-Reset")
+	retryAfter, okRetry := headerRetryAfter(h, now)
+	if !okLimit && !okRemaining && !okReset && !okRetry {
+		return rl, false
+	}
+
+	rl.Limit, rl.Remaining = limit, remaining
+	if okReset {
+		if reset > 1000000000 {
+			rl.Reset = time.Unix(int64(reset), 0)
+		} else {
+			rl.Reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	if okRetry {
+		rl.Remaining = 0
+		rl.Reset = retryAfter
+		if rl.Limit == 0 {
+			rl.Limit = 1
+		}
+	}
+	return rl, true
+}
+
+// headerInt return the integer value of the first header present.
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, n := range names {
+		if v := h.Get(n); v != "" {
+			i, err := strconv.Atoi(v)
+			if err == nil {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// headerRetryAfter return the time of the Retry-After header, in seconds or
+// as an HTTP date.
+func headerRetryAfter(h http.Header, now time.Time) (time.Time, bool) {
+	if seconds, ok := headerInt(h, "Retry-After"); ok {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		t, err := http.ParseTime(v)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
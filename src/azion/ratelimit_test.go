@@ -0,0 +1,101 @@
+package azion
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   RateLimit
+		wantOk bool
+	}{
+		{name: "no headers"},
+		{
+			name:   "reset in seconds",
+			header: map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "30"},
+			want:   RateLimit{Limit: 100, Remaining: 10, Reset: now.Add(30 * time.Second)},
+			wantOk: true,
+		},
+		{
+			name:   "reset timestamp",
+			header: map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "0", "RateLimit-Reset": "1704164705"},
+			want:   RateLimit{Limit: 100, Reset: time.Unix(1704164705, 0)},
+			wantOk: true,
+		},
+		{
+			name:   "without reset",
+			header: map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "10"},
+			want:   RateLimit{Limit: 100, Remaining: 10},
+			wantOk: true,
+		},
+		{
+			name:   "retry after seconds",
+			header: map[string]string{"Retry-After": "120"},
+			want:   RateLimit{Limit: 1, Reset: now.Add(2 * time.Minute)},
+			wantOk: true,
+		},
+		{
+			name:   "retry after date",
+			header: map[string]string{"X-RateLimit-Limit": "100", "Retry-After": "Tue, 02 Jan 2024 03:05:05 GMT"},
+			want:   RateLimit{Limit: 100, Reset: now.Add(time.Minute)},
+			wantOk: true,
+		},
+		{name: "invalid values", header: map[string]string{"X-RateLimit-Limit": "many", "Retry-After": "later"}},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		for k, v := range tt.header {
+			h.Set(k, v)
+		}
+		got, ok := parseRateLimit(h, now)
+		if ok != tt.wantOk {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.wantOk)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.Limit != tt.want.Limit || got.Remaining != tt.want.Remaining || !got.Reset.Equal(tt.want.Reset) || !got.UpdatedAt.Equal(now) {
+			t.Errorf("%s: rate limit = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	settings := RateLimitSettings{Threshold: 0.2, MaxDelay: 30 * time.Second, Window: time.Minute}
+	tests := []struct {
+		name string
+		last RateLimit
+		want time.Duration
+	}{
+		{name: "no quota reported", last: RateLimit{Limit: 100, Reset: now.Add(time.Minute)}},
+		{name: "above threshold", last: RateLimit{Limit: 100, Remaining: 50, Reset: now.Add(time.Minute), UpdatedAt: now}},
+		{name: "spread until reset", last: RateLimit{Limit: 100, Remaining: 9, Reset: now.Add(10 * time.Second), UpdatedAt: now}, want: time.Second},
+		{name: "exhausted", last: RateLimit{Limit: 100, Reset: now.Add(10 * time.Second), UpdatedAt: now}, want: 10 * time.Second},
+		{name: "capped", last: RateLimit{Limit: 100, Reset: now.Add(time.Hour), UpdatedAt: now}, want: 30 * time.Second},
+		{name: "reset passed", last: RateLimit{Limit: 100, Reset: now.Add(-time.Second), UpdatedAt: now}},
+		{name: "default window", last: RateLimit{Limit: 100, Remaining: 19, UpdatedAt: now}, want: 3 * time.Second},
+		{name: "default window passed", last: RateLimit{Limit: 100, UpdatedAt: now.Add(-2 * time.Minute)}},
+	}
+	for _, tt := range tests {
+		r := rateLimiter{settings: settings, last: tt.last}
+		if got := r.delay(now); got != tt.want {
+			t.Errorf("%s: delay = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// each request consumes the quota until the API reports it again
+	r := rateLimiter{settings: settings, last: RateLimit{Limit: 100, Remaining: 1, Reset: now.Add(10 * time.Second), UpdatedAt: now}}
+	if got := r.delay(now); got != 5*time.Second {
+		t.Errorf("first delay = %v, want 5s", got)
+	}
+	if got := r.delay(now); got != 10*time.Second {
+		t.Errorf("second delay = %v, want 10s", got)
+	}
+}
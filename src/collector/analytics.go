@@ -1,21 +1,95 @@
 package collector
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"regexp"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/mtulio/azion-exporter/src/azion"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/mtulio/azion-exporter/src/collector")
+
+func init() {
+	registerCollector("analytics", defaultEnabled, func(azionCli *azion.Client, config *Config) (Collector, error) {
+		return NewCollectorAnalytics(azionCli, config)
+	})
+}
+
+var (
+	datapointAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "metric", "datapoint_age_seconds"),
+		"Age of the Azion datapoint exported by the series, at scrape time.",
+		[]string{"metric", "dimension"},
+		nil,
+	)
+	revisionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "metric", "revisions_total"),
+		"Total of Azion datapoints of the series whose value changed after fetched.",
+		[]string{"metric", "dimension"},
+		nil,
+	)
+	correctionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "metric", "revision_correction_total"),
+		"Sum of the absolute changes of the revised Azion datapoints of the series.",
+		[]string{"metric", "dimension"},
+		nil,
+	)
+	settleLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "metric", "settle_lag_datapoints"),
+		"Number of latest Azion datapoints of the metric not settled, skipped by the strategies and counters.",
+		[]string{"metric"},
+		nil,
+	)
+	familyDroppedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "metric", "family_dropped_series_total"),
+		"Total of dimensions of the family dropped on each fetch, exceeding the family series limit.",
+		[]string{"metric"},
+		nil,
+	)
+	lastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "metric", "last_success_timestamp_seconds"),
+		"Time of the last successful fetch of the series.",
+		[]string{"metric", "dimension"},
+		nil,
+	)
+)
+
+// errNoDatapoints is returned when a series has no value to export.
+var errNoDatapoints = errors.New("no datapoints to export")
+
+// Staleness modes of the series not updated for Config.StaleIntervals.
+const (
+	StaleDrop = "drop"
+	StaleNaN  = "nan"
 )
 
 // Analytics keeps the collector info
 type Analytics struct {
 	AzionClient *azion.Client
-	Metrics     []Metric
+	Metrics     []*Metric
+
+	config    *Config
+	scheduler *scheduler
+
+	// refreshing is the refresh shared by the concurrent scrapes in scrape
+	// mode, nil when none is running, protected by refreshMu.
+	refreshMu  sync.Mutex
+	refreshing *refreshRun
+
+	// mu protects Metrics, defs and metadata, which change on each discovery.
+	mu       sync.RWMutex
+	defs     []metricDefinition
+	metadata *azion.AnalyticsMetadata
 }
 
 // Metric describe the metric attributes
@@ -23,253 +97,323 @@ type Metric struct {
 	Prom        *prometheus.Desc
 	Name        string
 	Description string
-	fCollector  func(m *Metric) error
-	Value       float64
+	fCollector  func(ctx context.Context, m *Metric) ([]Sample, error)
 	Labels      []string
 	LabelsValue []string
 	LabelsConst prometheus.Labels
+
+	family *metricDefinition
+	def    *metricDefinition
+
+	// members are the IDs of the metrics of the family dimensions known when
+	// the family was initialized, matched by the patterns of the family.
+	members []string
+
+	// store keeps the snapshot of the latest fetch, read by the scrapes.
+	store snapshotStore
+
+	// counter enables the counter mode, accumulating the datapoints of each
+	// series, kept by label value, into a total.
+	counter bool
+	series  map[string]*series
+
+	// legacy is the description of the gauge with its legacy name, when
+	// enabled.
+	legacy *prometheus.Desc
+
+	// lag estimates the settle lag of the datapoints from their revisions,
+	// only used by the fetch of the metric, settleLag is the latest one used.
+	lag       lagEstimator
+	settleLag atomic.Int64
+
+	// since is the time of the newest settled datapoint fetched of all the
+	// series, the next fetch requesting only the newer datapoints.
+	since time.Time
+
+	// dropped counts the dimensions of a family dropped by the series limit,
+	// lastDropped is the number of the latest fetch, only used by the fetch.
+	dropped     atomic.Uint64
+	lastDropped int
+
+	// running is set while a fetch is queued or running, skipped counts the
+	// fetches skipped by the scheduler.
+	running atomic.Bool
+	skipped atomic.Uint64
+
+	// nextRun is the time of the next fetch, only used by the updater.
+	nextRun time.Time
+}
+
+// definition return the definition of the metric or family.
+func (m *Metric) definition() *metricDefinition {
+	if m.family != nil {
+		return m.family
+	}
+	return m.def
+}
+
+// scale return the value of a datapoint in the base unit exported by the
+// metric, depending on the counter mode.
+func (m *Metric) scale(v float64) float64 {
+	if m.counter {
+		return m.definition().totalScale(v)
+	}
+	return m.definition().scale(v)
+}
+
+// Snapshot return the result of the latest fetch of the metric, or nil
+// before the first fetch.
+func (m *Metric) Snapshot() *Snapshot {
+	return m.store.Load()
+}
+
+// fetch runs the collector of the metric and publishes its result.
+func (m *Metric) fetch(ctx context.Context) {
+	src := Source{}
+	if d := m.definition(); d != nil {
+		src = Source{Product: d.Product, Metric: d.Metric, Dimension: d.Dimension}
+	}
+	now := time.Now()
+	samples, err := m.fCollector(ctx, m)
+	if err != nil {
+		// the next fetch requests the full window
+		m.since = time.Time{}
+		m.store.publishError(src, now, err)
+		return
+	}
+	m.store.publish(src, now, samples)
 }
 
 // NewCollectorAnalytics return the CollectorAnalytics object
-func NewCollectorAnalytics(aCli *azion.Client, msEnabled ...string) (*Analytics, error) {
+func NewCollectorAnalytics(aCli *azion.Client, config *Config) (*Analytics, error) {
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
 
 	ca := &Analytics{
 		AzionClient: aCli,
+		config:      config,
+		scheduler:   newScheduler(config.Workers),
+		defs:        append([]metricDefinition{}, analyticsMetrics...),
 	}
-	err := ca.InitMetrics(msEnabled...)
+
+	// the discovered metrics are added to the static ones, not required to
+	// match the filters, exported even when the discovery fails
+	strict := !config.Discover
+	err := ca.InitFamilies(strict, config.Families...)
 	if err != nil {
-		log.Info("collector.Analytics: error initializing metrics")
+		return nil, err
+	}
+	msEnabled, err := resolveMetrics(analyticsMetrics, strict, config.Filter...)
+	if err != nil {
+		return nil, err
+	}
+	err = ca.InitMetrics(msEnabled...)
+	if err != nil {
+		return nil, err
+	}
+	if config.Discover {
+		go func() { ca.InitDiscoveryUpdater(ca.discoverWithTimeout()) }()
+	}
+	if config.Mode != ModeScrape {
+		go ca.InitCollectorsUpdater()
 	}
-	go ca.InitCollectorsUpdater()
 	return ca, nil
 }
 
+// metrics return the current metrics.
+func (ca *Analytics) metrics() []*Metric {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.Metrics
+}
+
 // Update implements Collector and exposes related metrics
 func (ca *Analytics) Update(ch chan<- prometheus.Metric) error {
 	// done := make(chan bool)
+	metrics := ca.metrics()
 	wg := sync.WaitGroup{}
-	wg.Add(len(ca.Metrics))
+	wg.Add(len(metrics))
 
-	for mID := range ca.Metrics {
+	for _, m := range metrics {
 		go func(m *Metric, ch chan<- prometheus.Metric) {
-			if m.LabelsValue != nil {
-				ch <- prometheus.MustNewConstMetric(
-					m.Prom,
-					prometheus.GaugeValue,
-					m.Value,
-					m.LabelsValue...,
-				)
-			} else {
+			snap := m.Snapshot()
+			if snap == nil || snap.SucceededAt.IsZero() {
+				// never fetched successfully
+				wg.Done()
+				return
+			}
+			now := time.Now()
+			stale := ca.stale(m, snap, now)
+			ch <- prometheus.MustNewConstMetric(settleLagDesc, prometheus.GaugeValue, float64(m.settleLag.Load()), m.Name)
+			if m.family != nil {
+				ch <- prometheus.MustNewConstMetric(familyDroppedDesc, prometheus.CounterValue, float64(m.dropped.Load()), m.Name)
+			}
+			for _, s := range snap.Samples {
 				ch <- prometheus.MustNewConstMetric(
-					m.Prom,
+					lastSuccessDesc,
 					prometheus.GaugeValue,
-					m.Value,
-					m.LabelsValue...,
+					float64(snap.SucceededAt.UnixNano())/1e9,
+					m.Name,
+					s.LabelValue,
 				)
+				ch <- prometheus.MustNewConstMetric(revisionsDesc, prometheus.CounterValue, float64(s.Revisions), m.Name, s.LabelValue)
+				ch <- prometheus.MustNewConstMetric(correctionDesc, prometheus.CounterValue, m.scale(s.Correction), m.Name, s.LabelValue)
+				if stale {
+					if ca.config.StaleMode == StaleNaN {
+						ca.sendSample(ch, m, Sample{LabelValue: s.LabelValue, Value: math.NaN()})
+					}
+					continue
+				}
+				ca.sendSample(ch, m, s)
+				if !s.Time.IsZero() {
+					ch <- prometheus.MustNewConstMetric(
+						datapointAgeDesc,
+						prometheus.GaugeValue,
+						now.Sub(s.Time).Seconds(),
+						m.Name,
+						s.LabelValue,
+					)
+				}
 			}
 			// done <- true
 			wg.Done()
-		}(&ca.Metrics[mID], ch)
+		}(m, ch)
 	}
 
 	// wait to finish all go routines
 	wg.Wait()
+	ca.scheduler.collect(ch, metrics)
 	// <-done
 	return nil
 }
 
+// stale return true when the last successful fetch of the metric is older
+// than the configured number of polling intervals.
+func (ca *Analytics) stale(m *Metric, snap *Snapshot, now time.Time) bool {
+	if ca.config.StaleIntervals <= 0 {
+		return false
+	}
+	maxAge := time.Duration(ca.config.StaleIntervals) * ca.metricInterval(m)
+	return now.Sub(snap.SucceededAt) > maxAge
+}
+
+// sendSample sends the sample of the metric in the base unit, and in the
+// datapoints unit with the legacy name when enabled.
+func (ca *Analytics) sendSample(ch chan<- prometheus.Metric, m *Metric, s Sample) {
+	valueType := prometheus.GaugeValue
+	if m.counter {
+		valueType = prometheus.CounterValue
+	}
+	ch <- ca.sampleMetric(m.Prom, valueType, m.scale(s.Value), s)
+	if m.legacy != nil {
+		ch <- ca.sampleMetric(m.legacy, prometheus.GaugeValue, s.Value, s)
+	}
+}
+
+// sampleMetric return the sample with the value v, with the datapoint time
+// as timestamp when enabled.
+func (ca *Analytics) sampleMetric(desc *prometheus.Desc, valueType prometheus.ValueType, v float64, s Sample) prometheus.Metric {
+	pm := prometheus.MustNewConstMetric(
+		desc,
+		valueType,
+		v,
+		s.LabelValue,
+	)
+	if ca.config.Timestamps && !s.Time.IsZero() {
+		return prometheus.NewMetricWithTimestamp(s.Time, pm)
+	}
+	return pm
+}
+
+// initMetricType enables the counter mode of the metric when selected by
+// Config.Counters, or the legacy name of the gauges when enabled by
+// Config.LegacyNames, returning the metric.
+func (ca *Analytics) initMetricType(m *Metric) *Metric {
+	for _, p := range ca.config.Counters {
+		if m.matches(p) {
+			m.counter = true
+			m.Prom = m.definition().counterDesc()
+			return m
+		}
+	}
+	if ca.config.LegacyNames {
+		m.legacy = m.definition().legacyDesc()
+	}
+	return m
+}
+
 // InitMetrics initialize a list of metrics names and return error if fails.
+// Metrics already initialized, or exported by an enabled family, are skipped.
 func (ca *Analytics) InitMetrics(msEnabled ...string) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
 
-	for _, mName := range msEnabled {
-		m := Metric{}
-		reStatusCode := regexp.MustCompile(`^cd_status_code_*`)
-		reReq := regexp.MustCompile(`^cd_requests_*`)
-		reBW := regexp.MustCompile(`^cd_bandwidth_*`)
-		reDT := regexp.MustCompile(`^cd_data_transferred_*`)
-
-		switch {
-		case reReq.MatchString(mName):
-			{
-				m.Name = prometheus.BuildFQName(namespace, "cd", "requests_count")
-				m.Description = "Azion Analytics Content Delivery Requests Count"
-				m.Labels = []string{"type"}
-				switch mName {
-				case "cd_requests_total":
-					{
-						m.fCollector = ca.collectorWrapper("requests", "total")
-						m.LabelsValue = []string{"total"}
-					}
-				case "cd_requests_saved":
-					{
-						m.fCollector = ca.collectorWrapper("requests", "saved")
-						m.LabelsValue = []string{"saved"}
-					}
-				case "cd_requests_missed":
-					{
-						m.fCollector = ca.collectorWrapper("requests", "missed")
-						m.LabelsValue = []string{"missed"}
-					}
-				}
-			}
-		case reBW.MatchString(mName):
-			{
-				m.Name = prometheus.BuildFQName(namespace, "cd", "bandwidth_gb")
-				m.Description = "Azion Analytics Content Delivery Bandwidth Count"
-				m.Labels = []string{"type"}
-				switch mName {
-				case "cd_bandwidth_total":
-					{
-						m.fCollector = ca.collectorWrapper("bandwidth", "total")
-						m.LabelsValue = []string{"total"}
-					}
-				case "cd_bandwidth_saved":
-					{
-						m.fCollector = ca.collectorWrapper("bandwidth", "saved")
-						m.LabelsValue = []string{"saved"}
-					}
-				case "cd_bandwidth_missed":
-					{
-						m.fCollector = ca.collectorWrapper("bandwidth", "missed")
-						m.LabelsValue = []string{"missed"}
-					}
-				}
-			}
-		case reDT.MatchString(mName):
-			{
-				m.Name = prometheus.BuildFQName(namespace, "cd", "data_transferred_mb")
-				m.Description = "Azion Analytics Content Delivery Data Transferred in MB"
-				m.Labels = []string{"type"}
-				switch mName {
-				case "cd_data_transferred_total":
-					{
-						m.fCollector = ca.collectorWrapper("data_transferred", "total")
-						m.LabelsValue = []string{"total"}
-					}
-				case "cd_data_transferred_saved":
-					{
-						m.fCollector = ca.collectorWrapper("data_transferred", "saved")
-						m.LabelsValue = []string{"saved"}
-					}
-				case "cd_data_transferred_missed":
-					{
-						m.fCollector = ca.collectorWrapper("data_transferred", "missed")
-						m.LabelsValue = []string{"missed"}
-					}
-				}
-			}
-		case reStatusCode.MatchString(mName):
-			{
-				m.Name = prometheus.BuildFQName(namespace, "cd", "status_code_total")
-				m.Description = "Azion Analytics Content Delivery Status Code 5xx Total"
-				m.Labels = []string{"code"}
-				switch mName {
-				case "cd_status_code_2xx":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "2xx")
-						m.LabelsValue = []string{"2xx"}
-					}
-				case "cd_status_code_200":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "200")
-						m.LabelsValue = []string{"200"}
-					}
-				case "cd_status_code_204":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "204")
-						m.LabelsValue = []string{"204"}
-					}
-				case "cd_status_code_206":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "206")
-						m.LabelsValue = []string{"206"}
-					}
-				case "cd_status_code_3xx":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "3xx")
-						m.LabelsValue = []string{"3xx"}
-					}
-				case "cd_status_code_301":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "301")
-						m.LabelsValue = []string{"301"}
-					}
-				case "cd_status_code_302":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "302")
-						m.LabelsValue = []string{"302"}
-					}
-				case "cd_status_code_304":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "304")
-						m.LabelsValue = []string{"304"}
-					}
-				case "cd_status_code_4xx":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "4xx")
-						m.LabelsValue = []string{"4xx"}
-					}
-				case "cd_status_code_400":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "400")
-						m.LabelsValue = []string{"400"}
-					}
-				case "cd_status_code_403":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "403")
-						m.LabelsValue = []string{"403"}
-					}
-				case "cd_status_code_404":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "404")
-						m.LabelsValue = []string{"404"}
-					}
-				case "cd_status_code_5xx":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "5xx")
-						m.LabelsValue = []string{"5xx"}
-					}
-				case "cd_status_code_500":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "500")
-						m.LabelsValue = []string{"500"}
-					}
-				case "cd_status_code_502":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "502")
-						m.LabelsValue = []string{"502"}
-					}
-				case "cd_status_code_503":
-					{
-						m.fCollector = ca.collectorWrapper("status_code", "503")
-						m.LabelsValue = []string{"503"}
-					}
-
-				}
-			}
-		default:
-			fmt.Println("Metric init Error, metric definition found: ", mName)
+	defs, err := lookupMetricDefinitions(ca.defs, msEnabled...)
+	if err != nil {
+		return err
+	}
+	enabled := make(map[string]bool, len(ca.Metrics))
+	for _, m := range ca.Metrics {
+		enabled[m.Name] = true
+	}
+	for _, d := range defs {
+		if enabled[d.ID] || ca.familyEnabled(d.Product, d.Metric) {
 			continue
 		}
-		m.Prom = prometheus.NewDesc(
-			m.Name,
-			m.Description,
-			m.Labels, m.LabelsConst,
-		)
-		ca.Metrics = append(ca.Metrics, m)
+		ca.Metrics = append(ca.Metrics, ca.initMetricType(&Metric{
+			Prom:        d.desc(),
+			Name:        d.ID,
+			Description: d.Help,
+			fCollector:  ca.collectorWrapper(d),
+			Labels:      []string{d.Label},
+			LabelsValue: []string{d.Dimension},
+			def:         d,
+		}))
 	}
 	return nil
 }
 
-// InitCollectorsUpdater start the paralel auto update for each collector
+// InitCollectorsUpdater start the paralel auto update for each collector.
+// Each metric is fetched at startup and then on each polling interval by the
+// scheduler workers, skipping the metrics whose previous fetch is running.
+// A fetch is canceled when not finished within the polling interval.
 func (ca *Analytics) InitCollectorsUpdater() {
 	for {
-		for mID := range ca.Metrics {
-			go func(m *Metric) {
-				m.fCollector(m)
-			}(&ca.Metrics[mID])
+		now := time.Now()
+		due := []*Metric{}
+		wakeup := now.Add(maxUpdaterSleep)
+		for _, m := range ca.metrics() {
+			if !now.Before(m.nextRun) {
+				due = append(due, m)
+				interval := ca.metricInterval(m)
+				offset := metricOffset(m.Name, interval, ca.config.Spread)
+				m.nextRun = nextRun(now, interval, offset, ca.config.Jitter)
+			}
+			if m.nextRun.Before(wakeup) {
+				wakeup = m.nextRun
+			}
 		}
-		time.Sleep(time.Second * time.Duration(60))
+
+		if len(due) > 0 {
+			ctx, span := tracer.Start(context.Background(), "collector.Analytics.cycle")
+			span.SetAttributes(attribute.Int("azion.metrics", len(due)))
+
+			wg := sync.WaitGroup{}
+			for _, m := range due {
+				wg.Add(1)
+				if !ca.scheduler.enqueue(ctx, m, ca.metricInterval(m), wg.Done) {
+					wg.Done()
+				}
+			}
+			// the cycle span ends when all metrics were fetched
+			go func() {
+				wg.Wait()
+				span.End()
+			}()
+		}
+		time.Sleep(time.Until(wakeup))
 	}
 }
 
@@ -277,317 +421,70 @@ func (ca *Analytics) InitCollectorsUpdater() {
 // Metrics mapping / parser / cast
 //
 
-// metricAssertion asserts the datapoints to retrieve last valid value.
+// metricAssertion asserts the datapoints of the dimension dim to retrieve
+// the value selected by the strategy of the metric, see Strategy.Select, or
+// the counter total of the metrics in counter mode.
 // BUG Report: Azion Analytics API has delays to proccess latest datapoints,
-// the last one is always lower, sometimes more than it,
-// to prevent empty metrics, we will follow the strategy:
-// - we consider >=2min datapoint an 'safe value'; if it's <=0, then
-// - get the latest (>=2min) data point greater than 0;
-// The value will be: >= 2 min ago && > 0.
-func (ca *Analytics) metricAssertion(datapoints [][]interface{}) (float64, error) {
-
-	value := 0.0
-	posLatestDP := len(datapoints) - 2
-	for i := posLatestDP; i >= 0; i-- {
-		value = datapoints[i][1].(float64)
-		if value > 0 {
-			break
-		}
+// the last one is always lower, sometimes more than it, so the strategies
+// and counters skip the latest datapoints, the settle lag estimated from the
+// revisions of the datapoints.
+// The sample has the time of the datapoint chosen with its value.
+func (ca *Analytics) metricAssertion(m *Metric, dim string, datapoints []datapoint) (Sample, error) {
+	s := m.seriesState(dim)
+	dps := s.merge(datapoints, &m.lag)
+	lag := ca.settleLag(m)
+	if len(dps) > lag {
+		s.settled = dps[len(dps)-1-lag].Time
 	}
-	return value, nil
-
+	m.settleLag.Store(int64(lag))
+	if m.counter {
+		s.accumulate(dps, lag)
+		return s.sample(dim), nil
+	}
+	smp := s.sample(dim)
+	v, ts, ok := ca.metricStrategy(m).Select(dps, lag)
+	if !ok {
+		return smp, errNoDatapoints
+	}
+	smp.Value, smp.Time = v, ts
+	return smp, nil
 }
 
-func (ca *Analytics) collectorMetric(n, d string, args ...string) ([]byte, error) {
+func (ca *Analytics) collectorMetric(ctx context.Context, p, n, d string, args ...string) (*azion.MetricSeries, error) {
 
-	mData, err := ca.AzionClient.Analytics.GetMetricDimension(n, d, args...)
+	ms, err := ca.AzionClient.Analytics.GetProductMetricDimensionWithContext(ctx, p, n, d, args...)
 	if err != nil {
 		log.Info("Error getting metrics from API.")
 		return nil, err
 	}
-	b, err := json.Marshal(mData)
-	if err != nil {
-		return nil, err
-	}
 
-	return b, nil
+	return ms, nil
 }
 
-func (ca *Analytics) collectorWrapper(metric, dimension string) func(m *Metric) error {
-	return func(m *Metric) error {
+func (ca *Analytics) collectorWrapper(d *metricDefinition) func(ctx context.Context, m *Metric) ([]Sample, error) {
+	return func(ctx context.Context, m *Metric) ([]Sample, error) {
+		ctx, span := tracer.Start(ctx, "collector.Analytics.fetch")
+		span.SetAttributes(
+			attribute.String("azion.product", d.Product),
+			attribute.String("azion.metric", d.Metric),
+			attribute.String("azion.dimension", d.Dimension),
+		)
+		defer span.End()
 
-		b, err := ca.collectorMetric(metric, dimension, "date_from=last-hour")
+		ms, err := ca.collectorMetric(ctx, d.Product, d.Metric, d.Dimension, ca.fetchWindow(m)...)
 		if err != nil {
-			return err
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
 		}
-
-		md := metric + "_" + dimension
-		switch md {
-		case "data_transferred_total":
-			{
-				var mI azion.MetricRespDTTotal
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "data_transferred_saved":
-			{
-				var mI azion.MetricRespDTSaved
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "data_transferred_missed":
-			{
-				var mI azion.MetricRespDTMissed
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "bandwidth_total":
-			{
-				var mI azion.MetricRespBWTotal
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "bandwidth_saved":
-			{
-				var mI azion.MetricRespBWSaved
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "bandwidth_missed":
-			{
-				var mI azion.MetricRespBWMissed
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "requests_total":
-			{
-				var mI azion.MetricRespReqTotal
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "requests_missed":
-			{
-				var mI azion.MetricRespReqMissed
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "requests_saved":
-			{
-				var mI azion.MetricRespReqSaved
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_2xx":
-			{
-				var mI azion.MetricRespSCode2xx
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_200":
-			{
-				var mI azion.MetricRespSCode200
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_204":
-			{
-				var mI azion.MetricRespSCode204
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_206":
-			{
-				var mI azion.MetricRespSCode206
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_3xx":
-			{
-				var mI azion.MetricRespSCode3xx
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_301":
-			{
-				var mI azion.MetricRespSCode301
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_302":
-			{
-				var mI azion.MetricRespSCode302
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_304":
-			{
-				var mI azion.MetricRespSCode304
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_4xx":
-			{
-				var mI azion.MetricRespSCode4xx
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_400":
-			{
-				var mI azion.MetricRespSCode400
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_403":
-			{
-				var mI azion.MetricRespSCode403
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_404":
-			{
-				var mI azion.MetricRespSCode404
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_5xx":
-			{
-				var mI azion.MetricRespSCode5xx
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_502":
-			{
-				var mI azion.MetricRespSCode502
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
-		case "status_code_503":
-			{
-				var mI azion.MetricRespSCode503
-				// Casting metric payload
-				json.Unmarshal(b, &mI)
-				v, err := ca.metricAssertion(mI.Products.Prod1441740010.Metric.Dimension)
-				if err != nil {
-					return nil
-				}
-				m.Value = v
-			}
+		productID := ca.AzionClient.Analytics.ProductID(d.Product)
+		smp, err := ca.metricAssertion(m, d.Dimension, ms.Datapoints(productID, d.Metric, d.Dimension))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
 		}
-		return nil
+		m.since = m.seriesState(d.Dimension).settled
+		return []Sample{smp}, nil
 	}
 }
@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/mtulio/azion-exporter/src/azion"
+)
+
+// productPrefixes maps the product Alias to the prefix of the metric IDs.
+// Products without Alias are prefixed by p<ProductID>.
+var productPrefixes = map[string]string{
+	"ContentDelivery":   "cd",
+	"CloudStorage":      "cs",
+	"ImageOptimization": "io",
+	"LiveIngest":        "li",
+	"MediaPackager":     "mp",
+}
+
+var reInvalidNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// ListMetrics return the metric IDs selected by the config filter. When the
+// discovery is enabled the analytics metadata is requested to the API.
+func ListMetrics(aCli *azion.Client, config *Config) ([]string, error) {
+	if !config.Discover {
+		return ResolveMetrics(config.Filter...)
+	}
+	md, err := aCli.Analytics.GetMetadataWithContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defs := mergeMetricDefinitions(analyticsMetrics, discoveredMetricDefinitions(aCli.Analytics, md))
+	return resolveMetrics(defs, false, config.Filter...)
+}
+
+// discoverRetry is the first wait time before retrying a failed discovery,
+// doubled on each failure up to the discovery interval.
+const discoverRetry = 10 * time.Second
+
+// discoverTimeout bounds the metadata request of a discovery.
+const discoverTimeout = time.Minute
+
+// InitDiscoveryUpdater refresh the discovered metrics on each discovery
+// interval, err being the result of the previous discovery. A failed
+// discovery is retried sooner, backing off up to the discovery interval.
+func (ca *Analytics) InitDiscoveryUpdater(err error) {
+	interval := ca.config.DiscoverInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	retry := discoverRetry
+	for {
+		var wait time.Duration
+		wait, retry = discoverWait(err, retry, interval)
+		if err != nil {
+			log.Warnf("collector.Analytics: metrics discovery failed, retrying in %s: %s", wait, err)
+		}
+		time.Sleep(wait)
+		err = ca.discoverWithTimeout()
+	}
+}
+
+// discoverWait return the wait time before the next discovery and the next
+// retry wait time, given the result of the previous discovery and the current
+// retry wait time. The retry doubles up to the interval.
+func discoverWait(err error, retry, interval time.Duration) (time.Duration, time.Duration) {
+	if err == nil {
+		return interval, discoverRetry
+	}
+	if retry >= interval {
+		return interval, interval
+	}
+	return retry, min(retry*2, interval)
+}
+
+// discoverWithTimeout run a discovery bounded by discoverTimeout.
+func (ca *Analytics) discoverWithTimeout() error {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), discoverTimeout, azion.ErrTimeout)
+	defer cancel()
+	return ca.discover(ctx)
+}
+
+// discover requests the analytics metadata and initialize the discovered
+// families and metrics selected by the filters. Metrics already enabled are
+// kept.
+func (ca *Analytics) discover(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "collector.Analytics.discover")
+	defer span.End()
+
+	md, err := ca.AzionClient.Analytics.GetMetadataWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	ca.mu.Lock()
+	ca.metadata = md
+	ca.defs = mergeMetricDefinitions(ca.defs, discoveredMetricDefinitions(ca.AzionClient.Analytics, md))
+	ids, err := resolveMetrics(ca.defs, false, ca.config.Filter...)
+	ca.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	before := len(ca.metrics())
+	err = ca.InitFamilies(false, ca.config.Families...)
+	if err != nil {
+		return err
+	}
+	err = ca.InitMetrics(ids...)
+	if err != nil {
+		return err
+	}
+	if added := len(ca.metrics()) - before; added > 0 {
+		log.Infof("collector.Analytics: %d metrics discovered", added)
+	}
+	return nil
+}
+
+// discoveredMetricDefinitions return one definition for each product, metric
+// and dimension of the metadata, sorted by ID. Metrics already defined in
+// the analyticsMetrics table keep their Prometheus name, label and help.
+func discoveredMetricDefinitions(svc *azion.AnalyticsSvc, md *azion.AnalyticsMetadata) []metricDefinition {
+	defs := []metricDefinition{}
+	for productID, metrics := range md.Products {
+		product := svc.ProductAlias(productID)
+		prefix, ok := productPrefixes[product]
+		if !ok {
+			prefix = "p" + productID
+		}
+		for metric, dimensions := range metrics {
+			base := metricDefinition{
+				Product: product,
+				Metric:  metric,
+				Name:    metricName(prefix, metric),
+				Label:   "dimension",
+				Help:    fmt.Sprintf("Azion Analytics %s %s", product, metric),
+			}
+			for _, d := range analyticsMetrics {
+				if d.Product == product && d.Metric == metric {
+					base = d
+					base.Default = false
+					break
+				}
+			}
+			for _, dimension := range dimensions {
+				d := base
+				d.Dimension = dimension
+				d.ID = metricName(prefix, metric, dimension)
+				defs = append(defs, d)
+			}
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].ID < defs[j].ID })
+	return defs
+}
+
+// mergeMetricDefinitions return defs appended with the definitions of added
+// whose ID is not defined yet.
+func mergeMetricDefinitions(defs, added []metricDefinition) []metricDefinition {
+	known := make(map[string]bool, len(defs))
+	merged := append([]metricDefinition{}, defs...)
+	for _, d := range defs {
+		known[d.ID] = true
+	}
+	for _, d := range added {
+		if !known[d.ID] {
+			known[d.ID] = true
+			merged = append(merged, d)
+		}
+	}
+	return merged
+}
+
+// metricName return the metric name joining the API identifiers in lower
+// case, replacing the invalid characters by '_'.
+func metricName(parts ...string) string {
+	name := strings.ToLower(strings.Join(parts, "_"))
+	return strings.Trim(reInvalidNameChars.ReplaceAllString(name, "_"), "_")
+}
@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDiscoverWait(t *testing.T) {
+	errDiscover := errors.New("metadata unavailable")
+	interval := 2 * time.Minute
+
+	// the retry doubles up to the interval and stays there
+	retry := discoverRetry
+	want := []time.Duration{10, 20, 40, 80, 120, 120}
+	for i := 0; i < 100; i++ {
+		var wait time.Duration
+		wait, retry = discoverWait(errDiscover, retry, interval)
+		w := interval
+		if i < len(want) {
+			w = want[i] * time.Second
+		}
+		if wait != w {
+			t.Fatalf("failure %d: wait = %v, want %v", i+1, wait, w)
+		}
+	}
+
+	// a success waits the interval and resets the retry
+	wait, retry := discoverWait(nil, retry, interval)
+	if wait != interval || retry != discoverRetry {
+		t.Errorf("success: wait = %v, retry = %v, want %v, %v", wait, retry, interval, discoverRetry)
+	}
+
+	// an interval shorter than the first retry
+	if wait, _ := discoverWait(errDiscover, discoverRetry, time.Second); wait != time.Second {
+		t.Errorf("short interval: wait = %v, want 1s", wait)
+	}
+}
@@ -0,0 +1,240 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// defaultFamilyMaxSeries is the series limit of a family when not configured.
+const defaultFamilyMaxSeries = 50
+
+// familyDefinitions return one family definition for each product and metric
+// of defs, keeping the Prometheus name, label and help of its first
+// dimension. The family ID is the product prefix and the metric, such as
+// cd_status_code.
+func familyDefinitions(defs []metricDefinition) []metricDefinition {
+	families := []metricDefinition{}
+	known := make(map[string]bool)
+	for _, d := range defs {
+		prefix, ok := productPrefixes[d.Product]
+		if !ok {
+			prefix = "p" + d.Product
+		}
+		id := metricName(prefix, d.Metric)
+		if known[id] {
+			continue
+		}
+		known[id] = true
+
+		f := d
+		f.ID = id
+		f.Dimension = ""
+		families = append(families, f)
+	}
+	return families
+}
+
+// InitFamilies initialize the metric families selected by the patterns,
+// exporting one series by dimension found in the API response or in the
+// analytics metadata. Families already initialized are skipped.
+func (ca *Analytics) InitFamilies(strict bool, patterns ...string) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	families := familyDefinitions(ca.defs)
+	include := []string{}
+	for _, p := range patterns {
+		if strings.TrimSpace(p) != "" {
+			include = append(include, p)
+		}
+	}
+	if len(include) == 0 {
+		return nil
+	}
+	ids, err := resolveMetrics(families, strict, include...)
+	if err != nil {
+		return fmt.Errorf("families: %v", err)
+	}
+	defs, err := lookupMetricDefinitions(families, ids...)
+	if err != nil {
+		return err
+	}
+
+	enabled := make(map[string]bool, len(ca.Metrics))
+	for _, m := range ca.Metrics {
+		enabled[m.Name] = true
+	}
+	for _, d := range defs {
+		if enabled[d.ID] {
+			continue
+		}
+		// the family replaces the metrics of its dimensions
+		metrics := ca.Metrics[:0:0]
+		for _, m := range ca.Metrics {
+			if m.family != nil || m.def == nil || m.def.Product != d.Product || m.def.Metric != d.Metric {
+				metrics = append(metrics, m)
+			}
+		}
+		ca.Metrics = append(metrics, ca.initMetricType(&Metric{
+			Prom:        d.desc(),
+			Name:        d.ID,
+			Description: d.Help,
+			fCollector:  ca.familyCollector(d),
+			Labels:      []string{d.Label},
+			family:      d,
+			members:     familyMembers(ca.defs, d),
+		}))
+	}
+	return nil
+}
+
+// familyMembers return the IDs of the metrics of the family dimensions in defs.
+func familyMembers(defs []metricDefinition, family *metricDefinition) []string {
+	members := []string{}
+	for _, d := range defs {
+		if d.Product == family.Product && d.Metric == family.Metric && d.Dimension != "" {
+			members = append(members, d.ID)
+		}
+	}
+	return members
+}
+
+// familyEnabled return true when the family of the product and metric is
+// initialized. It must be called with mu held.
+func (ca *Analytics) familyEnabled(product, metric string) bool {
+	for _, m := range ca.Metrics {
+		if m.family != nil && m.family.Product == product && m.family.Metric == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// familyDimensions return the dimensions of the family in the analytics
+// metadata, if discovered.
+func (ca *Analytics) familyDimensions(productID, metric string) []string {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	if ca.metadata == nil {
+		return nil
+	}
+	return ca.metadata.Products[productID][metric]
+}
+
+// familyCollector return the collector of a metric family, fetching all the
+// dimensions of the metric. The dimensions in the metadata missing in the
+// response are exported as 0. The family exports the first dimensions seen up
+// to the family limit, dropping the others, see admit.
+func (ca *Analytics) familyCollector(d *metricDefinition) func(ctx context.Context, m *Metric) ([]Sample, error) {
+	return func(ctx context.Context, m *Metric) ([]Sample, error) {
+		ctx, span := tracer.Start(ctx, "collector.Analytics.fetchFamily")
+		span.SetAttributes(
+			attribute.String("azion.product", d.Product),
+			attribute.String("azion.metric", d.Metric),
+		)
+		defer span.End()
+
+		ms, err := ca.AzionClient.Analytics.GetProductMetricWithContext(ctx, d.Product, d.Metric, ca.fetchWindow(m)...)
+		if err != nil {
+			log.Info("Error getting metrics from API.")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		limit := ca.config.FamilyMaxSeries
+		if limit <= 0 {
+			limit = defaultFamilyMaxSeries
+		}
+		productID := ca.AzionClient.Analytics.ProductID(d.Product)
+		found := ms.Dimensions(productID, d.Metric)
+		known := make(map[string]bool)
+		for _, dim := range ca.familyDimensions(productID, d.Metric) {
+			known[dim] = true
+		}
+		dims := make([]string, 0, len(found)+len(known))
+		for dim := range known {
+			dims = append(dims, dim)
+		}
+		for dim := range found {
+			if !known[dim] {
+				dims = append(dims, dim)
+			}
+		}
+		sort.Strings(dims)
+
+		now := time.Now()
+		samples := []Sample{}
+		since := time.Time{}
+		dropped := 0
+		for _, dim := range dims {
+			s := m.admit(dim, limit, now)
+			if s == nil {
+				dropped++
+				continue
+			}
+			dps, ok := found[dim]
+			if !ok {
+				samples = append(samples, s.sample(dim))
+				continue
+			}
+			smp, err := ca.metricAssertion(m, dim, dps)
+			if err != nil {
+				if known[dim] {
+					samples = append(samples, s.sample(dim))
+				}
+				continue
+			}
+			samples = append(samples, smp)
+			if since.IsZero() || s.settled.Before(since) {
+				since = s.settled
+			}
+		}
+		m.since = since
+		m.prune(now.Add(-bufferWindow))
+
+		m.dropped.Add(uint64(dropped))
+		if dropped > 0 && dropped != m.lastDropped {
+			log.Warnf("collector.Analytics: family %s exceeds %d series, dropping %d dimensions", d.ID, limit, dropped)
+		}
+		m.lastDropped = dropped
+		span.SetAttributes(
+			attribute.Int("azion.series", len(samples)),
+			attribute.Int("azion.dropped_series", dropped),
+		)
+		return samples, nil
+	}
+}
+
+// admit return the state of the series of the dimension dim of a family,
+// marked as seen at now. A new dimension is admitted while the family has
+// less than limit series, nil is returned otherwise, so the exported series
+// and their state are bounded and keep the same dimensions between fetches.
+func (m *Metric) admit(dim string, limit int, now time.Time) *series {
+	s, ok := m.series[dim]
+	if !ok {
+		if len(m.series) >= limit {
+			return nil
+		}
+		s = m.seriesState(dim)
+	}
+	s.seen = now
+	return s
+}
+
+// prune removes the state of the series not seen since before, whose
+// dimensions are no longer returned by the API, freeing their slots.
+func (m *Metric) prune(before time.Time) {
+	for dim, s := range m.series {
+		if s.seen.Before(before) {
+			delete(m.series, dim)
+		}
+	}
+}
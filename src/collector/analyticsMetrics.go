@@ -0,0 +1,288 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricDefinition describes how an Azion Analytics metric dimension is
+// fetched and exposed. The analyticsMetrics table drives the metrics
+// initialization, the API requests and the exposition.
+type metricDefinition struct {
+	// ID is the metric name used by -metrics.filter.
+	ID string
+
+	// Product, Metric and Dimension identify the API series.
+	Product   string
+	Metric    string
+	Dimension string
+
+	// Name is the Prometheus metric name without the namespace, labeled by
+	// Label with the dimension as value. Unit is the unit of the datapoints,
+	// multiplied by Scale, when set, to the base unit of Name. LegacyName is
+	// the former name, exported in the datapoints unit.
+	Name       string
+	LegacyName string
+	Label      string
+	Unit       string
+	Scale      float64
+	Help       string
+
+	// TotalName and TotalScale replace the name and scale of the counter
+	// mode when Name is a rate, the counter accumulating the volume.
+	TotalName  string
+	TotalScale float64
+
+	// Strategy is the datapoint strategy of the metric when no rule of
+	// -metrics.strategy matches it, StrategyNonZero when unset.
+	Strategy Strategy
+
+	// Default enables the metric when -metrics.filter has no include pattern.
+	Default bool
+}
+
+// settledStrategy exports the latest settled value, zero included, for the
+// metrics where 0 is a meaningful value, such as the error status codes.
+var settledStrategy = Strategy{Name: StrategySettled, N: -1}
+
+// gbPerMinute is the scale of the datapoints in GB by minute to their mean
+// rate in bits per second.
+const gbPerMinute = 1e9 * 8 / 60
+
+// analyticsMetrics is the table of supported Azion Analytics metrics.
+//
+// The Prometheus names are in base units, the datapoints being scaled by
+// Scale, and LegacyName is the name exported before, in the Azion unit,
+// kept with -metrics.legacy-names:
+//
+//	cd_requests{type}                    cd_requests_count{type}
+//	cd_bandwidth_bits_per_second{type}   cd_bandwidth_gb{type}          1 GB by minute = 8e9/60 bits per second
+//	cd_data_transferred_bytes{type}      cd_data_transferred_mb{type}   1 MB = 1e6 bytes
+//	cd_responses{code}                   cd_status_code_total{code}
+//
+// The bandwidth datapoints are the GB served in the minute, exported as the
+// mean rate of the minute. The gauges are the value of the latest settled
+// minute, and the counters of the counter mode add the suffix _total, such as
+// cd_requests_total, the bandwidth counting the bytes served in
+// cd_bandwidth_bytes_total. The status codes use the settled strategy, a
+// minute without errors being 0.
+var analyticsMetrics = []metricDefinition{
+	// Content Delivery: requests
+	{ID: "cd_requests_total", Product: "ContentDelivery", Metric: "requests", Dimension: "total", Name: "cd_requests", LegacyName: "cd_requests_count", Label: "type", Unit: "requests", Help: "Azion Analytics Content Delivery requests", Default: true},
+	{ID: "cd_requests_saved", Product: "ContentDelivery", Metric: "requests", Dimension: "saved", Name: "cd_requests", LegacyName: "cd_requests_count", Label: "type", Unit: "requests", Help: "Azion Analytics Content Delivery requests", Default: true},
+	{ID: "cd_requests_missed", Product: "ContentDelivery", Metric: "requests", Dimension: "missed", Name: "cd_requests", LegacyName: "cd_requests_count", Label: "type", Unit: "requests", Help: "Azion Analytics Content Delivery requests", Default: true},
+
+	// Content Delivery: bandwidth
+	{ID: "cd_bandwidth_total", Product: "ContentDelivery", Metric: "bandwidth", Dimension: "total", Name: "cd_bandwidth_bits_per_second", LegacyName: "cd_bandwidth_gb", Label: "type", Unit: "GB by minute", Scale: gbPerMinute, TotalName: "cd_bandwidth_bytes_total", TotalScale: 1e9, Help: "Azion Analytics Content Delivery bandwidth", Default: true},
+	{ID: "cd_bandwidth_saved", Product: "ContentDelivery", Metric: "bandwidth", Dimension: "saved", Name: "cd_bandwidth_bits_per_second", LegacyName: "cd_bandwidth_gb", Label: "type", Unit: "GB by minute", Scale: gbPerMinute, TotalName: "cd_bandwidth_bytes_total", TotalScale: 1e9, Help: "Azion Analytics Content Delivery bandwidth"},
+	{ID: "cd_bandwidth_missed", Product: "ContentDelivery", Metric: "bandwidth", Dimension: "missed", Name: "cd_bandwidth_bits_per_second", LegacyName: "cd_bandwidth_gb", Label: "type", Unit: "GB by minute", Scale: gbPerMinute, TotalName: "cd_bandwidth_bytes_total", TotalScale: 1e9, Help: "Azion Analytics Content Delivery bandwidth"},
+
+	// Content Delivery: data transferred
+	{ID: "cd_data_transferred_total", Product: "ContentDelivery", Metric: "data_transferred", Dimension: "total", Name: "cd_data_transferred_bytes", LegacyName: "cd_data_transferred_mb", Label: "type", Unit: "MB", Scale: 1e6, Help: "Azion Analytics Content Delivery data transferred", Default: true},
+	{ID: "cd_data_transferred_saved", Product: "ContentDelivery", Metric: "data_transferred", Dimension: "saved", Name: "cd_data_transferred_bytes", LegacyName: "cd_data_transferred_mb", Label: "type", Unit: "MB", Scale: 1e6, Help: "Azion Analytics Content Delivery data transferred", Default: true},
+	{ID: "cd_data_transferred_missed", Product: "ContentDelivery", Metric: "data_transferred", Dimension: "missed", Name: "cd_data_transferred_bytes", LegacyName: "cd_data_transferred_mb", Label: "type", Unit: "MB", Scale: 1e6, Help: "Azion Analytics Content Delivery data transferred", Default: true},
+
+	// Content Delivery: status codes
+	{ID: "cd_status_code_2xx", Product: "ContentDelivery", Metric: "status_code", Dimension: "2xx", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy, Default: true},
+	{ID: "cd_status_code_200", Product: "ContentDelivery", Metric: "status_code", Dimension: "200", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_204", Product: "ContentDelivery", Metric: "status_code", Dimension: "204", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_206", Product: "ContentDelivery", Metric: "status_code", Dimension: "206", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_3xx", Product: "ContentDelivery", Metric: "status_code", Dimension: "3xx", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy, Default: true},
+	{ID: "cd_status_code_301", Product: "ContentDelivery", Metric: "status_code", Dimension: "301", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_302", Product: "ContentDelivery", Metric: "status_code", Dimension: "302", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_304", Product: "ContentDelivery", Metric: "status_code", Dimension: "304", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_4xx", Product: "ContentDelivery", Metric: "status_code", Dimension: "4xx", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy, Default: true},
+	{ID: "cd_status_code_400", Product: "ContentDelivery", Metric: "status_code", Dimension: "400", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_403", Product: "ContentDelivery", Metric: "status_code", Dimension: "403", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_404", Product: "ContentDelivery", Metric: "status_code", Dimension: "404", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_5xx", Product: "ContentDelivery", Metric: "status_code", Dimension: "5xx", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy, Default: true},
+	{ID: "cd_status_code_500", Product: "ContentDelivery", Metric: "status_code", Dimension: "500", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_502", Product: "ContentDelivery", Metric: "status_code", Dimension: "502", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+	{ID: "cd_status_code_503", Product: "ContentDelivery", Metric: "status_code", Dimension: "503", Name: "cd_responses", LegacyName: "cd_status_code_total", Label: "code", Unit: "responses", Help: "Azion Analytics Content Delivery responses by status code", Strategy: settledStrategy},
+}
+
+// ResolveMetrics return the metric IDs selected by the filter patterns, in
+// the definition order. Patterns are globs (cd_status_code_*) including the
+// metrics, or excluding them when prefixed by '!'. When no include pattern is
+// given the default metrics are included. An include pattern matching no
+// metric is an error.
+func ResolveMetrics(patterns ...string) ([]string, error) {
+	return resolveMetrics(analyticsMetrics, true, patterns...)
+}
+
+// resolveMetrics return the IDs of the definitions selected by the filter
+// patterns. When strict, an include pattern matching no definition is an
+// error.
+func resolveMetrics(defs []metricDefinition, strict bool, patterns ...string) ([]string, error) {
+	includes := []string{}
+	excludes := []string{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "!") {
+			excludes = append(excludes, p[1:])
+		} else {
+			includes = append(includes, p)
+		}
+	}
+
+	selected := make(map[string]bool)
+	if len(includes) == 0 {
+		for _, d := range defs {
+			selected[d.ID] = d.Default
+		}
+	}
+	unknown := []string{}
+	for _, p := range includes {
+		matched, err := matchMetrics(defs, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			unknown = append(unknown, p)
+		}
+		for _, id := range matched {
+			selected[id] = true
+		}
+	}
+	if strict && len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown metrics: %s; supported metrics: %s",
+			strings.Join(unknown, ","), strings.Join(SupportedMetrics(), ","))
+	}
+	for _, p := range excludes {
+		matched, err := matchMetrics(defs, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range matched {
+			selected[id] = false
+		}
+	}
+
+	ids := []string{}
+	for _, d := range defs {
+		if selected[d.ID] {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids, nil
+}
+
+// matchMetrics return the IDs of the definitions matching the glob pattern.
+func matchMetrics(defs []metricDefinition, pattern string) ([]string, error) {
+	ids := []string{}
+	for _, d := range defs {
+		ok, err := path.Match(pattern, d.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics pattern %q: %v", pattern, err)
+		}
+		if ok {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids, nil
+}
+
+// lookupMetricDefinitions return the definitions of the metric IDs, failing
+// when any ID is not defined.
+func lookupMetricDefinitions(defs []metricDefinition, ids ...string) ([]*metricDefinition, error) {
+	byID := make(map[string]*metricDefinition, len(defs))
+	for i := range defs {
+		byID[defs[i].ID] = &defs[i]
+	}
+
+	found := []*metricDefinition{}
+	unknown := []string{}
+	for _, id := range ids {
+		d, ok := byID[id]
+		if !ok {
+			unknown = append(unknown, id)
+			continue
+		}
+		found = append(found, d)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown metrics: %s; supported metrics: %s",
+			strings.Join(unknown, ","), strings.Join(SupportedMetrics(), ","))
+	}
+	return found, nil
+}
+
+// SupportedMetrics return the sorted IDs of the supported metrics.
+func SupportedMetrics() []string {
+	ids := make([]string, 0, len(analyticsMetrics))
+	for _, d := range analyticsMetrics {
+		ids = append(ids, d.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// counterDesc return the Prometheus description of the metric in counter
+// mode, TotalName or the name with the suffix _total.
+func (d *metricDefinition) counterDesc() *prometheus.Desc {
+	name := d.TotalName
+	if name == "" {
+		name = d.Name
+	}
+	if !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", name),
+		d.Help+", accumulated by minute since the exporter start.",
+		[]string{d.Label}, nil,
+	)
+}
+
+// desc return the Prometheus description of the metric.
+func (d *metricDefinition) desc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", d.Name),
+		d.Help+", in the latest settled minute.",
+		[]string{d.Label}, nil,
+	)
+}
+
+// legacyDesc return the Prometheus description of the metric with its legacy
+// name, or nil when it has none.
+func (d *metricDefinition) legacyDesc() *prometheus.Desc {
+	if d.LegacyName == "" {
+		return nil
+	}
+	help := d.Help
+	if d.Scale != 0 {
+		help += ", in " + d.Unit
+	}
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", d.LegacyName),
+		fmt.Sprintf("%s (deprecated, use %s).", help, prometheus.BuildFQName(namespace, "", d.Name)),
+		[]string{d.Label}, nil,
+	)
+}
+
+// scale return the value of a datapoint in the base unit of the metric.
+func (d *metricDefinition) scale(v float64) float64 {
+	if d.Scale == 0 {
+		return v
+	}
+	return v * d.Scale
+}
+
+// totalScale return the value of a datapoint in the base unit of the metric
+// in counter mode.
+func (d *metricDefinition) totalScale(v float64) float64 {
+	if d.TotalName == "" {
+		return d.scale(v)
+	}
+	if d.TotalScale == 0 {
+		return v
+	}
+	return v * d.TotalScale
+}
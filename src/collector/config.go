@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config keeps the options of the collectors.
+type Config struct {
+	// Filter is the list of metric patterns selecting the exported metrics,
+	// see ResolveMetrics.
+	Filter []string
+
+	// Families is the list of family patterns (cd_status_code) exporting one
+	// series by dimension found, limited to FamilyMaxSeries by family.
+	Families        []string
+	FamilyMaxSeries int
+
+	// Interval is the default polling interval of the metrics, overridden
+	// by the first matching Schedules. Spread is the fraction of the interval
+	// the fetches are distributed across by metric name, and Jitter delays
+	// each fetch randomly.
+	Interval  time.Duration
+	Schedules []Schedule
+	Spread    float64
+	Jitter    time.Duration
+
+	// Strategies selects the datapoint strategy of the metrics by the first
+	// matching rule, or the strategy of the metric definition, or
+	// StrategyNonZero.
+	Strategies []StrategyRule
+
+	// Incremental requests only the datapoints newer than the newest settled
+	// one fetched, instead of the last hour, merged in a buffer by series.
+	Incremental bool
+
+	// SettleLag is the number of latest datapoints not settled, skipped by
+	// the strategies and counters, or 0 to estimate it by metric from the
+	// revisions of the datapoints.
+	SettleLag int
+
+	// Counters is the list of patterns, matching the metric ID or product,
+	// of the metrics exported as counters accumulating the per-minute
+	// datapoints, with the suffix _total.
+	Counters []string
+
+	// LegacyNames exports the gauges with the legacy names too, in the unit
+	// of the datapoints, during the migration to the base unit names.
+	LegacyNames bool
+
+	// Timestamps exports the samples with the time of the Azion datapoint
+	// instead of the scrape time.
+	Timestamps bool
+
+	// StaleIntervals is the number of polling intervals without a successful
+	// fetch after which a series is stale, dropped or exported as NaN by
+	// StaleMode. Zero exports the last value forever.
+	StaleIntervals int
+	StaleMode      string
+
+	// Workers is the number of concurrent fetches.
+	Workers int
+
+	// Mode is the collection mode, ModeBackground fetching the metrics on
+	// each polling interval or ModeScrape on each scrape, reusing the results
+	// newer than MinAge.
+	Mode   string
+	MinAge time.Duration
+
+	// Discover enables the discovery of the metrics from the analytics
+	// metadata, refreshed on each DiscoverInterval.
+	Discover         bool
+	DiscoverInterval time.Duration
+}
+
+// ErrInvalidConfig is returned by the factory of a collector whose options are
+// invalid, failing the creation of the CollectorMaster.
+var ErrInvalidConfig = errors.New("invalid collector config")
+
+// Validate return an error when the options are invalid: an unknown
+// collection or stale mode, or filters or families matching no supported
+// metric when the discovery is disabled.
+func (c *Config) Validate() error {
+	switch c.Mode {
+	case "", ModeBackground, ModeScrape:
+	default:
+		return fmt.Errorf("invalid collection mode %q, expected %s or %s", c.Mode, ModeBackground, ModeScrape)
+	}
+	switch c.StaleMode {
+	case "", StaleDrop, StaleNaN:
+	default:
+		return fmt.Errorf("invalid stale mode %q, expected %s or %s", c.StaleMode, StaleDrop, StaleNaN)
+	}
+	if c.Discover {
+		return nil
+	}
+	if _, err := ResolveMetrics(c.Filter...); err != nil {
+		return err
+	}
+	families := []string{}
+	for _, p := range c.Families {
+		if strings.TrimSpace(p) != "" {
+			families = append(families, p)
+		}
+	}
+	if len(families) == 0 {
+		return nil
+	}
+	if _, err := resolveMetrics(familyDefinitions(analyticsMetrics), true, families...); err != nil {
+		return fmt.Errorf("families: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package collector
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "defaults", config: Config{}},
+		{name: "modes", config: Config{Mode: ModeScrape, StaleMode: StaleNaN}},
+		{name: "filter", config: Config{Filter: []string{"cd_status_code_*", "!cd_status_code_204"}}},
+		{name: "families", config: Config{Families: []string{"cd_status_code", ""}}},
+		{name: "unknown metric discovered", config: Config{Discover: true, Filter: []string{"cd_waf_*"}, Families: []string{"cd_waf"}}},
+
+		{name: "unknown mode", config: Config{Mode: "push"}, wantErr: true},
+		{name: "unknown stale mode", config: Config{StaleMode: "keep"}, wantErr: true},
+		{name: "unknown metric", config: Config{Filter: []string{"typo"}}, wantErr: true},
+		{name: "invalid pattern", config: Config{Filter: []string{"cd_["}}, wantErr: true},
+		{name: "unknown family", config: Config{Families: []string{"cd_waf"}}, wantErr: true},
+		{name: "invalid mode discovered", config: Config{Discover: true, Mode: "push"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
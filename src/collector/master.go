@@ -1,6 +1,11 @@
 package collector
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -43,14 +48,74 @@ var (
 	)
 )
 
-// NewCollectorMaster creates a new NodeCollector.
-func NewCollectorMaster(azionCli *azion.Client, metrics ...string) (*CollectorMaster, error) {
-	var err error
-	err = nil
-	collectors := make(map[string]Collector)
-	collectors["analytics"], err = NewCollectorAnalytics(azionCli, metrics...)
+// factoryFunc creates a collector.
+type factoryFunc func(azionCli *azion.Client, config *Config) (Collector, error)
+
+var (
+	factories      = make(map[string]factoryFunc)
+	collectorState = make(map[string]*bool)
+)
+
+// registerCollector registers the factory of the collector name, enabled by
+// default or not, and its flags -collector.<name> and -no-collector.<name>.
+func registerCollector(name string, isDefaultEnabled bool, factory factoryFunc) {
+	enabled := isDefaultEnabled
+	flag.BoolVar(&enabled, "collector."+name, isDefaultEnabled, fmt.Sprintf("Enable the %s collector", name))
+	flag.Var(negatedFlag{&enabled}, "no-collector."+name, fmt.Sprintf("Disable the %s collector", name))
+
+	collectorState[name] = &enabled
+	factories[name] = factory
+}
+
+// negatedFlag is a boolean flag setting the negation of its value.
+type negatedFlag struct {
+	value *bool
+}
+
+func (f negatedFlag) IsBoolFlag() bool { return true }
+
+func (f negatedFlag) String() string {
+	if f.value == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*f.value)
+}
+
+func (f negatedFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	*f.value = !v
+	return nil
+}
+
+// NewCollectorMaster creates a new NodeCollector with the enabled collectors,
+// failing when the config of an enabled collector is invalid. A collector
+// failing to be created otherwise is disabled, logging the reason.
+func NewCollectorMaster(azionCli *azion.Client, config *Config) (*CollectorMaster, error) {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	collectors := make(map[string]Collector)
+	for _, name := range names {
+		if !*collectorState[name] {
+			log.Infof("collector %s disabled", name)
+			continue
+		}
+		c, err := factories[name](azionCli, config)
+		if errors.Is(err, ErrInvalidConfig) {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		if err != nil {
+			log.Errorf("collector %s disabled: %s", name, err)
+			continue
+		}
+		log.Infof("collector %s enabled", name)
+		collectors[name] = c
 	}
 
 	return &CollectorMaster{
@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewCollectorMasterInvalidConfig(t *testing.T) {
+	enabled := collectorState["analytics"]
+	defer func(v bool) { *enabled = v }(*enabled)
+	config := &Config{Mode: "push"}
+
+	// the options of a disabled collector are not validated
+	*enabled = false
+	cm, err := NewCollectorMaster(nil, config)
+	if err != nil {
+		t.Fatalf("disabled collector error = %v, want nil", err)
+	}
+	if len(cm.Collectors) != 0 {
+		t.Errorf("collectors = %v, want none", cm.Collectors)
+	}
+
+	*enabled = true
+	if _, err := NewCollectorMaster(nil, config); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("enabled collector error = %v, want %v", err, ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,194 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Collection modes of the collectors.
+const (
+	// ModeBackground fetches the metrics on each polling interval.
+	ModeBackground = "background"
+	// ModeScrape fetches the metrics on each scrape, see Refresher.
+	ModeScrape = "scrape"
+)
+
+// defaultMinAge is the age of the results reused by the scrapes when not
+// configured.
+const defaultMinAge = 30 * time.Second
+
+// Refresher is implemented by the collectors fetching the metrics on each
+// scrape, refreshed before the scrape within the context deadline.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// Refresh refreshes the collectors fetching the metrics on scrape, returning
+// the first error.
+func (cm *CollectorMaster) Refresh(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(cm.Collectors))
+	for _, c := range cm.Collectors {
+		r, ok := c.(Refresher)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(r Refresher) {
+			errs <- r.Refresh(ctx)
+			wg.Done()
+		}(r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshRun is a refresh shared by the concurrent scrapes, running on a
+// context detached from the scrapes, canceled at the latest deadline of the
+// scrapes waiting it.
+type refreshRun struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error
+
+	// deadline is the latest deadline of the scrapes, unbounded set when a
+	// scrape has none, and timer cancels the refresh at the deadline. They
+	// are protected by the refreshMu of the collector.
+	deadline  time.Time
+	unbounded bool
+	timer     *time.Timer
+}
+
+// extendRefresh postpones the cancellation of the refresh run to the
+// deadline of ctx when later, or disables it when ctx has no deadline. It
+// must be called with refreshMu held.
+func (ca *Analytics) extendRefresh(ctx context.Context, run *refreshRun) {
+	if run.unbounded {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok:
+		run.unbounded = true
+		if run.timer != nil {
+			run.timer.Stop()
+		}
+	case run.timer == nil:
+		run.deadline = deadline
+		run.timer = time.AfterFunc(time.Until(deadline), func() {
+			ca.refreshMu.Lock()
+			defer ca.refreshMu.Unlock()
+			// the deadline may be extended while the timer fires
+			if !run.unbounded && !time.Now().Before(run.deadline) {
+				run.cancel(context.DeadlineExceeded)
+			}
+		})
+	case deadline.After(run.deadline):
+		run.deadline = deadline
+		run.timer.Reset(time.Until(deadline))
+	}
+}
+
+// Refresh fetches the metrics whose results are older than the minimum age,
+// waiting them until the context is done, in scrape mode. Concurrent scrapes
+// share the same refresh, which runs until the latest of their deadlines, so
+// a scrape does not cut the refresh of a scrape with a longer timeout. The
+// metrics not fetched in time are exported with the results of the previous
+// fetch.
+func (ca *Analytics) Refresh(ctx context.Context) error {
+	if ca.config.Mode != ModeScrape {
+		return nil
+	}
+
+	ca.refreshMu.Lock()
+	run := ca.refreshing
+	if run == nil || run.ctx.Err() != nil {
+		run = ca.startRefresh(ctx)
+	}
+	ca.extendRefresh(ctx, run)
+	ca.refreshMu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startRefresh starts a refresh keeping the values of ctx, such as the trace
+// span, but not its cancellation. It must be called with refreshMu held.
+func (ca *Analytics) startRefresh(ctx context.Context) *refreshRun {
+	rctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	run := &refreshRun{ctx: rctx, cancel: cancel, done: make(chan struct{})}
+	ca.refreshing = run
+	go func() {
+		run.err = ca.refresh(rctx)
+
+		ca.refreshMu.Lock()
+		if run.timer != nil {
+			run.timer.Stop()
+		}
+		if ca.refreshing == run {
+			ca.refreshing = nil
+		}
+		ca.refreshMu.Unlock()
+		cancel(context.Canceled)
+		close(run.done)
+	}()
+	return run
+}
+
+// refresh fetches the metrics whose last fetch failed or is older than the
+// minimum age.
+func (ca *Analytics) refresh(ctx context.Context) error {
+	minAge := ca.config.MinAge
+	if minAge <= 0 {
+		minAge = defaultMinAge
+	}
+
+	ctx, span := tracer.Start(ctx, "collector.Analytics.refresh")
+	defer span.End()
+
+	now := time.Now()
+	wg := sync.WaitGroup{}
+	fetched := 0
+	for _, m := range ca.metrics() {
+		if snap := m.Snapshot(); snap != nil && snap.Err == nil && now.Sub(snap.FetchedAt) < minAge {
+			continue
+		}
+		wg.Add(1)
+		if !ca.scheduler.enqueue(ctx, m, ca.metricInterval(m), wg.Done) {
+			wg.Done()
+			continue
+		}
+		fetched++
+	}
+	span.SetAttributes(attribute.Int("azion.metrics", fetched))
+
+	waited := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		return nil
+	case <-ctx.Done():
+		err := context.Cause(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRefreshCollector return a collector in scrape mode with one metric
+// whose fetch takes delay, counting the fetches and their errors.
+func testRefreshCollector(delay time.Duration, fetches, failures *atomic.Int32) *Analytics {
+	ca := &Analytics{
+		config:    &Config{Mode: ModeScrape, MinAge: time.Minute, Interval: time.Minute},
+		scheduler: newScheduler(1),
+	}
+	ca.Metrics = []*Metric{{
+		Name: "test",
+		fCollector: func(ctx context.Context, m *Metric) ([]Sample, error) {
+			fetches.Add(1)
+			select {
+			case <-time.After(delay):
+				return []Sample{{LabelValue: "total", Value: 1}}, nil
+			case <-ctx.Done():
+				failures.Add(1)
+				return nil, ctx.Err()
+			}
+		},
+	}}
+	return ca
+}
+
+func TestRefreshLongestDeadline(t *testing.T) {
+	var fetches, failures atomic.Int32
+	ca := testRefreshCollector(150*time.Millisecond, &fetches, &failures)
+
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i, timeout := range []time.Duration{50 * time.Millisecond, time.Second} {
+		wg.Add(1)
+		go func(i int, timeout time.Duration) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			errs[i] = ca.Refresh(ctx)
+		}(i, timeout)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("short scrape error = %v, want %v", errs[0], context.DeadlineExceeded)
+	}
+	if errs[1] != nil {
+		t.Errorf("long scrape error = %v, want nil", errs[1])
+	}
+	if fetches.Load() != 1 || failures.Load() != 0 {
+		t.Errorf("fetches = %d, failures = %d, want 1 shared fetch not canceled", fetches.Load(), failures.Load())
+	}
+	if snap := ca.Metrics[0].Snapshot(); snap == nil || snap.Err != nil {
+		t.Errorf("snapshot = %+v, want a successful fetch", snap)
+	}
+
+	// the results newer than the minimum age are reused
+	if err := ca.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("fetches = %d, want the results reused", fetches.Load())
+	}
+}
+
+func TestRefreshCanceledAtDeadline(t *testing.T) {
+	var fetches, failures atomic.Int32
+	ca := testRefreshCollector(time.Second, &fetches, &failures)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ca.Refresh(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the shared refresh ends at the deadline, freeing the metric
+	deadline := time.Now().Add(time.Second)
+	for ca.Metrics[0].running.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if failures.Load() != 1 {
+		t.Errorf("failures = %d, want the fetch canceled", failures.Load())
+	}
+	if snap := ca.Metrics[0].Snapshot(); snap == nil || snap.Err == nil {
+		t.Errorf("snapshot = %+v, want a failed fetch", snap)
+	}
+}
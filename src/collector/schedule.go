@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultInterval is the polling interval when not configured.
+	defaultInterval = 60 * time.Second
+
+	// maxUpdaterSleep is the longest the updater sleeps, picking up the
+	// metrics added by the discovery.
+	maxUpdaterSleep = 5 * time.Second
+)
+
+// Schedule overrides the polling interval of the metrics matching Pattern,
+// a glob over the metric ID (cd_status_code_*) or the product Alias
+// (ContentDelivery).
+type Schedule struct {
+	Pattern  string
+	Interval time.Duration
+}
+
+// ParseSchedules parses a list of schedules separated by comma in the
+// format pattern=interval, such as "cd_status_code_*=1m,cd_bandwidth_*=5m".
+func ParseSchedules(s string) ([]Schedule, error) {
+	schedules := []Schedule{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid schedule %q, expected pattern=interval", item)
+		}
+		if _, err := path.Match(kv[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid schedule pattern %q: %v", kv[0], err)
+		}
+		interval, err := time.ParseDuration(kv[1])
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid schedule interval %q", kv[1])
+		}
+		schedules = append(schedules, Schedule{Pattern: kv[0], Interval: interval})
+	}
+	return schedules, nil
+}
+
+// matches return true when the glob pattern matches the metric ID or product.
+// A family is matched by the patterns of its dimensions too: the pattern
+// matching a member ID, or the family ID followed by any dimension, such as
+// cd_status_code_* for the family cd_status_code.
+func (m *Metric) matches(pattern string) bool {
+	if ok, _ := path.Match(pattern, m.Name); ok {
+		return true
+	}
+	if m.family != nil {
+		// a pattern prefixed by the family ID matches a dimension, and a
+		// trailing glob matches the empty dimension
+		if strings.HasPrefix(pattern, m.Name+"_") {
+			return true
+		}
+		if ok, _ := path.Match(pattern, m.Name+"_"); ok {
+			return true
+		}
+		for _, id := range m.members {
+			if ok, _ := path.Match(pattern, id); ok {
+				return true
+			}
+		}
+	}
+	if d := m.definition(); d != nil && d.Product != "" {
+		ok, _ := path.Match(pattern, d.Product)
+		return ok
+	}
+	return false
+}
+
+// metricInterval return the polling interval of the metric: the first
+// schedule matching its ID or product, or the default interval.
+func (ca *Analytics) metricInterval(m *Metric) time.Duration {
+	for _, s := range ca.config.Schedules {
+		if m.matches(s.Pattern) {
+			return s.Interval
+		}
+	}
+	if ca.config.Interval > 0 {
+		return ca.config.Interval
+	}
+	return defaultInterval
+}
+
+// metricOffset return the delay of the metric runs after the interval
+// boundaries, a fraction spread of the interval picked by hashing the metric
+// name, so the metrics are fetched evenly across the interval and each one
+// always at the same time.
+func metricOffset(name string, interval time.Duration, spread float64) time.Duration {
+	if spread <= 0 {
+		return 0
+	}
+	if spread > 1 {
+		spread = 1
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return time.Duration(float64(h.Sum32()) / (1 << 32) * spread * float64(interval))
+}
+
+// nextRun return the next time a metric polled each interval runs after now.
+// Runs are aligned to the interval boundaries, which are minute boundaries
+// for intervals multiple of a minute, matching Azion datapoints granularity,
+// delayed by the metric offset and by a random jitter.
+func nextRun(now time.Time, interval, offset, jitter time.Duration) time.Time {
+	next := now.Add(-offset).Truncate(interval).Add(interval + offset)
+	if jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(jitter))))
+	}
+	return next
+}
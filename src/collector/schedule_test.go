@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetricMatches(t *testing.T) {
+	ca := &Analytics{
+		config: &Config{
+			Counters:  []string{"cd_status_code_*"},
+			Schedules: []Schedule{{Pattern: "cd_status_code_5xx", Interval: 5 * time.Minute}},
+		},
+		defs: analyticsMetrics,
+	}
+	if err := ca.InitFamilies(true, "cd_status_code", "cd_bandwidth"); err != nil {
+		t.Fatal(err)
+	}
+	families := map[string]*Metric{}
+	for _, m := range ca.Metrics {
+		families[m.Name] = m
+	}
+	statusCode, bandwidth := families["cd_status_code"], families["cd_bandwidth"]
+	if statusCode == nil || bandwidth == nil {
+		t.Fatalf("families = %v, want cd_status_code and cd_bandwidth", families)
+	}
+
+	tests := []struct {
+		pattern string
+		m       *Metric
+		want    bool
+	}{
+		{pattern: "cd_status_code", m: statusCode, want: true},
+		{pattern: "cd_status_code_*", m: statusCode, want: true},
+		{pattern: "cd_*", m: statusCode, want: true},
+		{pattern: "cd_status_code_5xx", m: statusCode, want: true},
+		{pattern: "cd_status_code_?xx", m: statusCode, want: true},
+		{pattern: "ContentDelivery", m: statusCode, want: true},
+		{pattern: "cd_status_code_429", m: statusCode, want: true},
+		{pattern: "cd_requests_*", m: statusCode},
+		{pattern: "cd_status_code_*", m: bandwidth},
+		{pattern: "cd_status", m: statusCode},
+
+		{pattern: "cd_status_code_*", m: &Metric{Name: "cd_status_code_5xx"}, want: true},
+		{pattern: "cd_status_code_5xx_*", m: &Metric{Name: "cd_status_code_5xx"}},
+	}
+	for _, tt := range tests {
+		if got := tt.m.matches(tt.pattern); got != tt.want {
+			t.Errorf("%s matches(%q) = %v, want %v", tt.m.Name, tt.pattern, got, tt.want)
+		}
+	}
+
+	// the counter and schedule of the dimensions apply to the family
+	if !statusCode.counter || bandwidth.counter {
+		t.Errorf("counter = %v, %v, want only the status code family", statusCode.counter, bandwidth.counter)
+	}
+	if got := ca.metricInterval(statusCode); got != 5*time.Minute {
+		t.Errorf("status code interval = %v, want 5m", got)
+	}
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/mtulio/azion-exporter/src/azion"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultWorkers is the number of concurrent fetches when not configured.
+	defaultWorkers = 4
+
+	// maxQueueDepth is the number of fetches waiting a worker, due fetches
+	// are skipped when it is full.
+	maxQueueDepth = 1024
+)
+
+var (
+	schedulerSkippedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("azion_exporter", "scheduler", "skipped_total"),
+		"Total of fetches skipped because the previous fetch of the metric was still running or the queue was full.",
+		[]string{"metric"},
+		nil,
+	)
+	schedulerQueueDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("azion_exporter", "scheduler", "queue_depth"),
+		"Number of fetches waiting a worker.",
+		nil,
+		nil,
+	)
+	schedulerWorkersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("azion_exporter", "scheduler", "workers"),
+		"Number of workers fetching the metrics.",
+		nil,
+		nil,
+	)
+)
+
+// fetchJob is a metric fetch waiting a worker, canceled after timeout, done
+// is called when finished.
+type fetchJob struct {
+	ctx     context.Context
+	m       *Metric
+	timeout time.Duration
+	done    func()
+}
+
+// scheduler runs the metric fetches on a bounded pool of workers, running at
+// most one fetch by metric at a time.
+type scheduler struct {
+	queue   chan fetchJob
+	workers int
+}
+
+// newScheduler return a scheduler running the given number of workers.
+func newScheduler(workers int) *scheduler {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	s := &scheduler{
+		queue:   make(chan fetchJob, maxQueueDepth),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// worker runs the queued fetches. A fetch is canceled after its timeout, so
+// a hung API request frees the worker and the next fetch of the metric, and
+// is counted as a failure by the circuit breaker.
+func (s *scheduler) worker() {
+	for job := range s.queue {
+		ctx, cancel := context.WithTimeoutCause(job.ctx, job.timeout, azion.ErrTimeout)
+		job.m.fetch(ctx)
+		cancel()
+		job.m.running.Store(false)
+		job.done()
+	}
+}
+
+// enqueue queues the fetch of m, canceled after timeout, returning false
+// when it is skipped because the previous fetch of m is still queued or
+// running, or the queue is full.
+func (s *scheduler) enqueue(ctx context.Context, m *Metric, timeout time.Duration, done func()) bool {
+	if !m.running.CompareAndSwap(false, true) {
+		m.skipped.Add(1)
+		log.Warnf("collector.Analytics: skipping fetch of %s, the previous one is still running", m.Name)
+		return false
+	}
+	select {
+	case s.queue <- fetchJob{ctx: ctx, m: m, timeout: timeout, done: done}:
+		return true
+	default:
+		m.running.Store(false)
+		m.skipped.Add(1)
+		log.Warnf("collector.Analytics: skipping fetch of %s, the queue is full", m.Name)
+		return false
+	}
+}
+
+// collect sends the scheduler metrics.
+func (s *scheduler) collect(ch chan<- prometheus.Metric, metrics []*Metric) {
+	for _, m := range metrics {
+		ch <- prometheus.MustNewConstMetric(schedulerSkippedDesc, prometheus.CounterValue, float64(m.skipped.Load()), m.Name)
+	}
+	ch <- prometheus.MustNewConstMetric(schedulerQueueDesc, prometheus.GaugeValue, float64(len(s.queue)))
+	ch <- prometheus.MustNewConstMetric(schedulerWorkersDesc, prometheus.GaugeValue, float64(s.workers))
+}
@@ -0,0 +1,186 @@
+package collector
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/mtulio/azion-exporter/src/azion"
+)
+
+// bufferWindow is the time range of the datapoints buffered by series, the
+// range of a full window fetch.
+const bufferWindow = time.Hour
+
+// series keeps the state of a series of a metric between its fetches, which
+// never run concurrently, so it is only accessed by the fetch of the metric.
+type series struct {
+	// buffer is the last datapoint fetched by minute, by Unix time, settled
+	// the time of the newest settled one. revisions is the number of minutes
+	// whose value changed after fetched and correction the sum of the
+	// absolute changes.
+	buffer     map[int64]datapoint
+	settled    time.Time
+	revisions  uint64
+	correction float64
+
+	// counted is the value accumulated by settled minute, by Unix time,
+	// total the sum of the values accumulated and last the time of the
+	// newest settled datapoint.
+	counted map[int64]float64
+	total   float64
+	last    time.Time
+
+	// seen is the time of the last fetch returning the dimension of a family
+	// series, which is pruned when not seen for the buffer window.
+	seen time.Time
+}
+
+// seriesState return the state of the series of the dimension dim.
+func (m *Metric) seriesState(dim string) *series {
+	if m.series == nil {
+		m.series = make(map[string]*series)
+	}
+	s, ok := m.series[dim]
+	if !ok {
+		s = &series{}
+		m.series[dim] = s
+	}
+	return s
+}
+
+// fetchWindow return the query arguments of the time range of the next fetch
+// of the metric: from the newest settled datapoint fetched minus the settle
+// lag, or the last hour after a restart, an error or when disabled.
+func (ca *Analytics) fetchWindow(m *Metric) []string {
+	if !ca.config.Incremental || m.since.IsZero() {
+		return []string{"date_from=last-hour"}
+	}
+	now := time.Now().UTC()
+	from := m.since.Add(-time.Duration(ca.settleLag(m)) * time.Minute)
+	if now.Sub(from) >= bufferWindow {
+		return []string{"date_from=last-hour"}
+	}
+	return []string{
+		"date_from=" + url.QueryEscape(from.UTC().Format(azion.DatapointLayout)),
+		"date_to=" + url.QueryEscape(now.Format(azion.DatapointLayout)),
+	}
+}
+
+// sample return the sample of the dimension dim with the counter total and
+// the revisions of the series.
+func (s *series) sample(dim string) Sample {
+	return Sample{
+		LabelValue: dim,
+		Value:      s.total,
+		Time:       s.last,
+		Revisions:  s.revisions,
+		Correction: s.correction,
+	}
+}
+
+// merge records the datapoints in the buffer of the series, returning the
+// datapoints buffered in the last bufferWindow ordered by time. The revisions
+// of the minutes already seen with a different value are counted and their
+// positions from the latest datapoint recorded by the lag estimator. Azion
+// revises the latest datapoints while they are processed. Datapoints without
+// time are returned as is, not buffered.
+func (s *series) merge(dps []datapoint, e *lagEstimator) []datapoint {
+	for _, dp := range dps {
+		if dp.Time.IsZero() {
+			return dps
+		}
+	}
+	if s.buffer == nil {
+		s.buffer = make(map[int64]datapoint)
+	}
+	revised := []int64{}
+	for _, dp := range dps {
+		ts := dp.Time.Unix()
+		prev, seen := s.buffer[ts]
+		if seen && !prev.Null && !dp.Null && prev.Value != dp.Value {
+			s.revisions++
+			s.correction += math.Abs(dp.Value - prev.Value)
+			revised = append(revised, ts)
+		}
+		s.buffer[ts] = dp
+	}
+
+	newest := int64(0)
+	for ts := range s.buffer {
+		if ts > newest {
+			newest = ts
+		}
+	}
+	merged := make([]datapoint, 0, len(s.buffer))
+	for ts, dp := range s.buffer {
+		if ts <= newest-int64(bufferWindow/time.Second) {
+			delete(s.buffer, ts)
+			continue
+		}
+		merged = append(merged, dp)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+
+	for _, ts := range revised {
+		i := sort.Search(len(merged), func(i int) bool {
+			return merged[i].Time.Unix() >= ts
+		})
+		e.observe(len(merged) - 1 - i)
+	}
+	return merged
+}
+
+// accumulate adds the settled datapoints, all but the lag latest ones, not
+// accumulated yet to the counter total. The datapoints are de-duplicated by
+// time and the increase of a revised minute is added, the total never
+// decreases. The first call with settled datapoints only records them as
+// accumulated, so the counter starts at 0 instead of adding the whole window
+// at startup.
+func (s *series) accumulate(dps []datapoint, lag int) {
+	var settled []datapoint
+	if len(dps) > lag {
+		settled = dps[:len(dps)-lag]
+	}
+
+	// counted is only empty until the first settled datapoint is recorded
+	baseline := len(s.counted) == 0
+	if s.counted == nil {
+		s.counted = make(map[int64]float64)
+	}
+	oldest := time.Time{}
+	for _, dp := range settled {
+		if dp.Null || dp.Time.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || dp.Time.Before(oldest) {
+			oldest = dp.Time
+		}
+		if dp.Time.After(s.last) {
+			s.last = dp.Time
+		}
+
+		ts := dp.Time.Unix()
+		prev, seen := s.counted[ts]
+		switch {
+		case baseline || !seen:
+			s.counted[ts] = dp.Value
+			if !baseline {
+				s.total += dp.Value
+			}
+		case dp.Value > prev:
+			s.counted[ts] = dp.Value
+			s.total += dp.Value - prev
+		}
+	}
+
+	// the minutes older than the window are not returned by the API anymore
+	for ts := range s.counted {
+		if !oldest.IsZero() && ts < oldest.Unix() {
+			delete(s.counted, ts)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package collector
+
+import (
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtulio/azion-exporter/src/azion"
+)
+
+// testWindow return the datapoints of the values by minute from the minute
+// from of testStart, NaN being a null datapoint.
+func testWindow(from int, values ...float64) []datapoint {
+	dps := testDatapoints(values...)
+	for i := range dps {
+		dps[i].Time = dps[i].Time.Add(time.Duration(from) * time.Minute)
+	}
+	return dps
+}
+
+func TestSeriesAccumulate(t *testing.T) {
+	null := math.NaN()
+	type step struct {
+		from        int
+		values      []float64
+		lag         int
+		wantTotal   float64
+		wantCounted int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "baseline on first call",
+			steps: []step{
+				{from: 0, values: []float64{1, 2, 3, 4}, lag: 1, wantTotal: 0, wantCounted: 3},
+			},
+		},
+		{
+			name: "new settled minutes",
+			steps: []step{
+				{from: 0, values: []float64{1, 2, 3, 4}, lag: 1, wantTotal: 0, wantCounted: 3},
+				{from: 0, values: []float64{1, 2, 3, 4, 5}, lag: 1, wantTotal: 4, wantCounted: 4},
+				{from: 0, values: []float64{1, 2, 3, 4, 5, 6, 7}, lag: 1, wantTotal: 15, wantCounted: 6},
+			},
+		},
+		{
+			name: "same window counted once",
+			steps: []step{
+				{from: 0, values: []float64{1, 2}, lag: 0, wantTotal: 0, wantCounted: 2},
+				{from: 0, values: []float64{1, 2, 3}, lag: 0, wantTotal: 3, wantCounted: 3},
+				{from: 0, values: []float64{1, 2, 3}, lag: 0, wantTotal: 3, wantCounted: 3},
+			},
+		},
+		{
+			name: "upward revision adds the increase",
+			steps: []step{
+				{from: 0, values: []float64{1, 2}, lag: 0, wantTotal: 0, wantCounted: 2},
+				{from: 0, values: []float64{1, 2, 3}, lag: 0, wantTotal: 3, wantCounted: 3},
+				{from: 0, values: []float64{1, 2, 7}, lag: 0, wantTotal: 7, wantCounted: 3},
+			},
+		},
+		{
+			name: "downward revision is ignored",
+			steps: []step{
+				{from: 0, values: []float64{1, 2}, lag: 0, wantTotal: 0, wantCounted: 2},
+				{from: 0, values: []float64{1, 2, 5}, lag: 0, wantTotal: 5, wantCounted: 3},
+				{from: 0, values: []float64{1, 2, 3}, lag: 0, wantTotal: 5, wantCounted: 3},
+				{from: 0, values: []float64{1, 2, 6}, lag: 0, wantTotal: 6, wantCounted: 3},
+			},
+		},
+		{
+			name: "revision of a baseline minute adds the increase",
+			steps: []step{
+				{from: 0, values: []float64{1, 2}, lag: 0, wantTotal: 0, wantCounted: 2},
+				{from: 0, values: []float64{1, 4}, lag: 0, wantTotal: 2, wantCounted: 2},
+			},
+		},
+		{
+			name: "unsettled minutes wait the lag",
+			steps: []step{
+				{from: 0, values: []float64{1, 2, 3}, lag: 2, wantTotal: 0, wantCounted: 1},
+				{from: 0, values: []float64{1, 2, 3, 4}, lag: 2, wantTotal: 2, wantCounted: 2},
+				{from: 0, values: []float64{1, 5, 3, 4}, lag: 2, wantTotal: 5, wantCounted: 2},
+			},
+		},
+		{
+			name: "lag over the window",
+			steps: []step{
+				{from: 0, values: []float64{1, 2}, lag: 3, wantTotal: 0, wantCounted: 0},
+				{from: 0, values: []float64{1, 2, 3}, lag: 3, wantTotal: 0, wantCounted: 0},
+				{from: 0, values: []float64{1, 2, 3, 4}, lag: 3, wantTotal: 0, wantCounted: 1},
+				{from: 0, values: []float64{1, 2, 3, 4, 5}, lag: 3, wantTotal: 2, wantCounted: 2},
+			},
+		},
+		{
+			name: "baseline waits a settled datapoint",
+			steps: []step{
+				{from: 0, values: []float64{null, null}, lag: 0, wantTotal: 0, wantCounted: 0},
+				{from: 0, values: []float64{1, 2, 3}, lag: 0, wantTotal: 0, wantCounted: 3},
+				{from: 0, values: []float64{1, 2, 3, 4}, lag: 0, wantTotal: 4, wantCounted: 4},
+			},
+		},
+		{
+			name: "null minutes are skipped",
+			steps: []step{
+				{from: 0, values: []float64{1, null}, lag: 0, wantTotal: 0, wantCounted: 1},
+				{from: 0, values: []float64{1, null, 3}, lag: 0, wantTotal: 3, wantCounted: 2},
+				{from: 0, values: []float64{1, 2, 3}, lag: 0, wantTotal: 5, wantCounted: 3},
+			},
+		},
+		{
+			name: "minutes older than the window are evicted",
+			steps: []step{
+				{from: 0, values: []float64{1, 2, 3, 4}, lag: 0, wantTotal: 0, wantCounted: 4},
+				{from: 2, values: []float64{3, 4, 5, 6}, lag: 0, wantTotal: 11, wantCounted: 4},
+				{from: 4, values: []float64{5, 6}, lag: 0, wantTotal: 11, wantCounted: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &series{}
+			for i, st := range tt.steps {
+				s.accumulate(testWindow(st.from, st.values...), st.lag)
+				if s.total != st.wantTotal {
+					t.Errorf("step %d: total = %v, want %v", i, s.total, st.wantTotal)
+				}
+				if len(s.counted) != st.wantCounted {
+					t.Errorf("step %d: counted minutes = %d, want %d", i, len(s.counted), st.wantCounted)
+				}
+			}
+		})
+	}
+}
+
+func TestSeriesAccumulateLast(t *testing.T) {
+	s := &series{}
+	s.accumulate(testWindow(0, 1, 2, 3), 1)
+	if want := testStart.Add(time.Minute); !s.last.Equal(want) {
+		t.Errorf("last = %v, want %v", s.last, want)
+	}
+	// an older window does not move the last settled time back
+	s.accumulate(testWindow(-5, 1, 2), 0)
+	if want := testStart.Add(time.Minute); !s.last.Equal(want) {
+		t.Errorf("last = %v, want %v", s.last, want)
+	}
+	smp := s.sample("total")
+	if smp.LabelValue != "total" || smp.Value != s.total || !smp.Time.Equal(s.last) {
+		t.Errorf("sample = %+v", smp)
+	}
+}
+
+func TestFetchWindow(t *testing.T) {
+	now := time.Now().UTC()
+	since := now.Add(-10 * time.Minute).Truncate(time.Minute)
+	lastHour := []string{"date_from=last-hour"}
+	tests := []struct {
+		name     string
+		config   Config
+		since    time.Time
+		wantFrom time.Time // zero for the last hour
+	}{
+		{name: "disabled", config: Config{SettleLag: 2}, since: since},
+		{name: "first fetch", config: Config{Incremental: true, SettleLag: 2}},
+		{name: "configured lag", config: Config{Incremental: true, SettleLag: 2}, since: since, wantFrom: since.Add(-2 * time.Minute)},
+		{name: "estimated lag", config: Config{Incremental: true}, since: since, wantFrom: since.Add(-defaultSettleLag * time.Minute)},
+		{name: "older than the buffer", config: Config{Incremental: true, SettleLag: 2}, since: now.Add(-bufferWindow)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := &Analytics{config: &tt.config}
+			args := ca.fetchWindow(&Metric{since: tt.since})
+			if tt.wantFrom.IsZero() {
+				if !reflect.DeepEqual(args, lastHour) {
+					t.Errorf("fetchWindow() = %q, want %q", args, lastHour)
+				}
+				return
+			}
+			if len(args) != 2 {
+				t.Fatalf("fetchWindow() = %q, want date_from and date_to", args)
+			}
+			if want := "date_from=" + url.QueryEscape(tt.wantFrom.Format(azion.DatapointLayout)); args[0] != want {
+				t.Errorf("fetchWindow() from = %q, want %q", args[0], want)
+			}
+			if !strings.HasPrefix(args[1], "date_to=") {
+				t.Fatalf("fetchWindow() to = %q, want date_to", args[1])
+			}
+			v, err := url.QueryUnescape(strings.TrimPrefix(args[1], "date_to="))
+			if err != nil {
+				t.Fatal(err)
+			}
+			to, err := time.ParseInLocation(azion.DatapointLayout, v, time.UTC)
+			if err != nil {
+				t.Fatalf("fetchWindow() to = %q: %v", v, err)
+			}
+			if d := to.Sub(now); d < -time.Second || d > time.Minute {
+				t.Errorf("fetchWindow() to = %v, want about %v", to, now)
+			}
+		})
+	}
+}
+
+// testMinutes return the minutes from testStart of the datapoints.
+func testMinutes(dps []datapoint) []int {
+	minutes := make([]int, len(dps))
+	for i, dp := range dps {
+		minutes[i] = int(dp.Time.Sub(testStart) / time.Minute)
+	}
+	return minutes
+}
+
+// testPoint return the datapoint of the minute of testStart.
+func testPoint(minute int, v float64) datapoint {
+	return testWindow(minute, v)[0]
+}
+
+func TestSeriesMerge(t *testing.T) {
+	null := math.NaN()
+	tests := []struct {
+		name           string
+		fetches        [][]datapoint
+		wantMinutes    []int
+		wantValues     []float64
+		wantRevisions  uint64
+		wantCorrection float64
+		wantAges       []int
+	}{
+		{
+			name:        "ordered by time",
+			fetches:     [][]datapoint{{testPoint(3, 3), testPoint(1, 1), testPoint(2, 2)}},
+			wantMinutes: []int{1, 2, 3},
+			wantValues:  []float64{1, 2, 3},
+		},
+		{
+			name:        "merged across fetches",
+			fetches:     [][]datapoint{testWindow(0, 0, 1, 2), testWindow(2, 2, 3)},
+			wantMinutes: []int{0, 1, 2, 3},
+			wantValues:  []float64{0, 1, 2, 3},
+		},
+		{
+			name:        "evicts the minutes older than the window",
+			fetches:     [][]datapoint{testWindow(0, 0, 1, 2), testWindow(61, 61)},
+			wantMinutes: []int{2, 61},
+			wantValues:  []float64{2, 61},
+		},
+		{
+			name:        "keeps the window of a late fetch",
+			fetches:     [][]datapoint{testWindow(61, 61), testWindow(0, 0, 1, 2)},
+			wantMinutes: []int{2, 61},
+			wantValues:  []float64{2, 61},
+		},
+		{
+			name:           "revisions counted by position",
+			fetches:        [][]datapoint{testWindow(0, 1, 2, 3), testWindow(1, 5, 3, 4)},
+			wantMinutes:    []int{0, 1, 2, 3},
+			wantValues:     []float64{1, 5, 3, 4},
+			wantRevisions:  1,
+			wantCorrection: 3,
+			wantAges:       []int{2},
+		},
+		{
+			name:           "downward revision",
+			fetches:        [][]datapoint{testWindow(0, 1, 9), testWindow(0, 1, 4)},
+			wantMinutes:    []int{0, 1},
+			wantValues:     []float64{1, 4},
+			wantRevisions:  1,
+			wantCorrection: 5,
+			wantAges:       []int{0},
+		},
+		{
+			name:        "null is not a revision",
+			fetches:     [][]datapoint{testWindow(0, 1), testWindow(0, null), testWindow(0, 4)},
+			wantMinutes: []int{0},
+			wantValues:  []float64{4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &series{}
+			e := &lagEstimator{}
+			var merged []datapoint
+			for _, dps := range tt.fetches {
+				merged = s.merge(dps, e)
+			}
+			if got := testMinutes(merged); !reflect.DeepEqual(got, tt.wantMinutes) {
+				t.Errorf("merge() minutes = %v, want %v", got, tt.wantMinutes)
+			}
+			values := make([]float64, len(merged))
+			for i, dp := range merged {
+				values[i] = dp.Value
+			}
+			if !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("merge() values = %v, want %v", values, tt.wantValues)
+			}
+			if len(s.buffer) != len(tt.wantMinutes) {
+				t.Errorf("buffer = %d minutes, want %d", len(s.buffer), len(tt.wantMinutes))
+			}
+			if s.revisions != tt.wantRevisions || s.correction != tt.wantCorrection {
+				t.Errorf("revisions = %d, correction = %v, want %d, %v", s.revisions, s.correction, tt.wantRevisions, tt.wantCorrection)
+			}
+			if len(e.ages) != len(tt.wantAges) || (len(e.ages) > 0 && !reflect.DeepEqual(e.ages, tt.wantAges)) {
+				t.Errorf("observed ages = %v, want %v", e.ages, tt.wantAges)
+			}
+		})
+	}
+}
+
+func TestSeriesMergeWithoutTime(t *testing.T) {
+	s := &series{}
+	dps := []datapoint{testPoint(0, 1), {Value: 2}}
+	if got := s.merge(dps, &lagEstimator{}); !reflect.DeepEqual(got, dps) {
+		t.Errorf("merge() = %v, want the datapoints as is", got)
+	}
+	if len(s.buffer) != 0 {
+		t.Errorf("buffer = %d minutes, want none", len(s.buffer))
+	}
+}
@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	// lagSamples is the number of latest revisions the settle lag is
+	// estimated from, and lagMinSamples the number needed to estimate it.
+	lagSamples    = 256
+	lagMinSamples = 10
+
+	// lagQuantile is the fraction of the revisions older than the settle lag
+	// datapoints are settled after.
+	lagQuantile = 0.95
+
+	// maxSettleLag is the highest settle lag estimated.
+	maxSettleLag = 30
+)
+
+// ParseSettleLag parses the settle lag of the datapoints, a number of
+// datapoints or "auto" to estimate it, returned as 0.
+func ParseSettleLag(s string) (int, error) {
+	if s == "auto" {
+		return 0, nil
+	}
+	lag, err := strconv.Atoi(s)
+	if err != nil || lag < 1 {
+		return 0, fmt.Errorf("invalid settle lag %q, expected auto or a number of datapoints", s)
+	}
+	return lag, nil
+}
+
+// lagEstimator estimates the number of latest datapoints of a metric still
+// processed by Azion from the positions, counted from the latest datapoint,
+// of the datapoints revised in the latest fetches.
+type lagEstimator struct {
+	ages []int
+	next int
+}
+
+// observe records the revision of a datapoint at the position age.
+func (e *lagEstimator) observe(age int) {
+	if len(e.ages) < lagSamples {
+		e.ages = append(e.ages, age)
+		return
+	}
+	e.ages[e.next] = age
+	e.next = (e.next + 1) % lagSamples
+}
+
+// lag return the settle lag: the datapoints at least as old as the lag
+// quantile of the revisions are settled. The default lag def is returned
+// until enough revisions are observed.
+func (e *lagEstimator) lag(def int) int {
+	if len(e.ages) < lagMinSamples {
+		return def
+	}
+	ages := append([]int{}, e.ages...)
+	sort.Ints(ages)
+	lag := ages[int(float64(len(ages)-1)*lagQuantile)]
+	if lag > maxSettleLag {
+		lag = maxSettleLag
+	}
+	return lag
+}
+
+// settleLag return the settle lag of the metric, configured or estimated.
+func (ca *Analytics) settleLag(m *Metric) int {
+	if ca.config.SettleLag > 0 {
+		return ca.config.SettleLag
+	}
+	return m.lag.lag(defaultSettleLag)
+}
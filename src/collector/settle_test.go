@@ -0,0 +1,84 @@
+package collector
+
+import "testing"
+
+func TestLagEstimator(t *testing.T) {
+	repeat := func(n, age int) []int {
+		ages := make([]int, n)
+		for i := range ages {
+			ages[i] = age
+		}
+		return ages
+	}
+	seq := func(n int) []int {
+		ages := make([]int, n)
+		for i := range ages {
+			ages[i] = i
+		}
+		return ages
+	}
+	tests := []struct {
+		name string
+		ages [][]int // observed in order
+		def  int
+		want int
+	}{
+		{name: "no samples", def: 3, want: 3},
+		{name: "below min samples", ages: [][]int{repeat(lagMinSamples-1, 5)}, def: 1, want: 1},
+		{name: "min samples", ages: [][]int{repeat(lagMinSamples, 5)}, def: 1, want: 5},
+		{name: "quantile of ten", ages: [][]int{seq(10)}, def: 1, want: 8},
+		{name: "quantile of twenty", ages: [][]int{seq(20)}, def: 1, want: 18},
+		{name: "unordered", ages: [][]int{{9, 0, 8, 1, 7, 2, 6, 3, 5, 4}}, def: 1, want: 8},
+		{name: "outliers over the quantile", ages: [][]int{repeat(95, 2), repeat(5, 40)}, def: 1, want: 2},
+		{name: "zero lag", ages: [][]int{repeat(20, 0)}, def: 1, want: 0},
+		{name: "capped", ages: [][]int{repeat(20, maxSettleLag+10)}, def: 1, want: maxSettleLag},
+		{name: "full ring", ages: [][]int{repeat(lagSamples, 4)}, def: 1, want: 4},
+		{name: "ring wraps over the oldest", ages: [][]int{repeat(lagSamples, 9), repeat(lagSamples, 2)}, def: 1, want: 2},
+		{name: "ring keeps the newest", ages: [][]int{repeat(lagSamples, 0), repeat(20, 9)}, def: 1, want: 9},
+		// the quantile index of a full ring is 242: 14 samples of 9 reach it, 13 do not
+		{name: "ring partly overwritten", ages: [][]int{repeat(lagSamples, 9), repeat(lagSamples-14, 2)}, def: 1, want: 9},
+		{name: "ring overwritten past the quantile", ages: [][]int{repeat(lagSamples, 9), repeat(lagSamples-13, 2)}, def: 1, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &lagEstimator{}
+			for _, ages := range tt.ages {
+				for _, age := range ages {
+					e.observe(age)
+				}
+			}
+			if len(e.ages) > lagSamples {
+				t.Fatalf("samples = %d, want at most %d", len(e.ages), lagSamples)
+			}
+			if got := e.lag(tt.def); got != tt.want {
+				t.Errorf("lag() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSettleLag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "auto", want: 0},
+		{in: "1", want: 1},
+		{in: "12", want: 12},
+		{in: "0", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "Auto", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSettleLag(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSettleLag(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSettleLag(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
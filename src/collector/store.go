@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sample is one exported series of a metric: the value of the dimension
+// LabelValue and the time of the Azion datapoint it was read from. Revisions
+// and Correction are the number and the absolute size of the changes of the
+// datapoints already fetched.
+type Sample struct {
+	LabelValue string
+	Value      float64
+	Time       time.Time
+	Revisions  uint64
+	Correction float64
+}
+
+// Source identifies the Azion analytics query a snapshot was fetched from.
+// Dimension is empty for metric families.
+type Source struct {
+	Product   string
+	Metric    string
+	Dimension string
+}
+
+// Snapshot is the result of a metric fetch, published at once by the
+// updater and read by the scrapes. Snapshots are immutable once published:
+// a fetch publishes a new one instead of changing the current.
+type Snapshot struct {
+	Samples []Sample
+	Source  Source
+
+	// FetchedAt is the time of the fetch, Err its error. A failed fetch keeps
+	// the Samples of the last successful one, fetched at SucceededAt.
+	FetchedAt   time.Time
+	SucceededAt time.Time
+	Err         error
+}
+
+// snapshotStore keeps the latest snapshot of a metric.
+type snapshotStore struct {
+	current atomic.Pointer[Snapshot]
+}
+
+// Load return the latest snapshot, or nil before the first fetch.
+func (s *snapshotStore) Load() *Snapshot {
+	return s.current.Load()
+}
+
+// publish replaces the snapshot by the samples fetched from src at fetchedAt.
+func (s *snapshotStore) publish(src Source, fetchedAt time.Time, samples []Sample) {
+	s.current.Store(&Snapshot{
+		Samples:     samples,
+		Source:      src,
+		FetchedAt:   fetchedAt,
+		SucceededAt: fetchedAt,
+	})
+}
+
+// publishError replaces the snapshot by a failed fetch, keeping the samples
+// of the last successful one.
+func (s *snapshotStore) publishError(src Source, fetchedAt time.Time, err error) {
+	snap := &Snapshot{
+		Source:    src,
+		FetchedAt: fetchedAt,
+		Err:       err,
+	}
+	if prev := s.current.Load(); prev != nil {
+		snap.Samples = prev.Samples
+		snap.SucceededAt = prev.SucceededAt
+	}
+	s.current.Store(snap)
+}
@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mtulio/azion-exporter/src/azion"
+)
+
+// defaultSettleLag is the number of latest datapoints still processed by
+// Azion, skipped by the strategies until the settle lag is estimated: the
+// last datapoint is always lower.
+const defaultSettleLag = 1
+
+// Datapoint strategies, selecting the exported value of a series.
+const (
+	// StrategyNonZero is the latest settled value greater than 0, or 0.
+	StrategyNonZero = "nonzero"
+	// StrategySettled is the value N datapoints before the latest, or the
+	// latest settled value, zero included.
+	StrategySettled = "settled"
+	// StrategyLatest is the latest value, zero included.
+	StrategyLatest = "latest"
+	// StrategySum, StrategyMean and StrategyMax aggregate the last N
+	// settled values.
+	StrategySum  = "sum"
+	StrategyMean = "mean"
+	StrategyMax  = "max"
+)
+
+// datapoint is an Azion datapoint, Null when the API has no value.
+type datapoint = azion.Datapoint
+
+// Strategy selects the exported value of a series from its datapoints. N is
+// the lag of StrategySettled, -1 for the settle lag, or the window of the
+// aggregations.
+type Strategy struct {
+	Name string
+	N    int
+}
+
+// ParseStrategy parses a strategy in the format name[:N], such as
+// "settled", "settled:2" or "sum:5".
+func ParseStrategy(s string) (Strategy, error) {
+	kv := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	st := Strategy{Name: kv[0]}
+	switch st.Name {
+	case StrategyNonZero, StrategyLatest:
+		if len(kv) == 2 {
+			return st, fmt.Errorf("invalid strategy %q, %s has no argument", s, st.Name)
+		}
+		return st, nil
+	case StrategySettled:
+		st.N = -1
+	case StrategySum, StrategyMean, StrategyMax:
+		st.N = 1
+	default:
+		return st, fmt.Errorf("invalid strategy %q, expected %s, %s, %s, %s, %s or %s", s,
+			StrategyNonZero, StrategySettled, StrategyLatest, StrategySum, StrategyMean, StrategyMax)
+	}
+	if len(kv) == 2 {
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 || (n == 0 && st.Name != StrategySettled) {
+			return st, fmt.Errorf("invalid strategy argument %q", s)
+		}
+		st.N = n
+	}
+	return st, nil
+}
+
+// String return the strategy in the format parsed by ParseStrategy.
+func (st Strategy) String() string {
+	if st.Name == StrategyNonZero || st.Name == StrategyLatest || st.N < 0 {
+		return st.Name
+	}
+	return st.Name + ":" + strconv.Itoa(st.N)
+}
+
+// StrategyRule selects the strategy of the metrics matching Pattern, a glob
+// over the metric ID or the product Alias, as Schedule.
+type StrategyRule struct {
+	Pattern  string
+	Strategy Strategy
+}
+
+// ParseStrategies parses a list of strategies separated by comma in the
+// format pattern=strategy, such as "cd_status_code_*=latest,cd_requests_*=sum:5".
+func ParseStrategies(s string) ([]StrategyRule, error) {
+	rules := []StrategyRule{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid strategy %q, expected pattern=strategy", item)
+		}
+		if _, err := path.Match(kv[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid strategy pattern %q: %v", kv[0], err)
+		}
+		st, err := ParseStrategy(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, StrategyRule{Pattern: kv[0], Strategy: st})
+	}
+	return rules, nil
+}
+
+// metricStrategy return the strategy of the metric: the first rule matching
+// its ID or product, the strategy of its definition, or StrategyNonZero.
+func (ca *Analytics) metricStrategy(m *Metric) Strategy {
+	for _, r := range ca.config.Strategies {
+		if m.matches(r.Pattern) {
+			return r.Strategy
+		}
+	}
+	if d := m.definition(); d != nil && d.Strategy.Name != "" {
+		return d.Strategy
+	}
+	return Strategy{Name: StrategyNonZero}
+}
+
+// Select return the value of the datapoints and the time of the newest
+// datapoint used, or false when there is no value to export. The lag latest
+// datapoints are not settled, skipped by the strategies but latest.
+func (st Strategy) Select(dps []datapoint, lag int) (float64, time.Time, bool) {
+	switch st.Name {
+	case StrategyLatest:
+		return latestValue(dps, len(dps)-1)
+	case StrategySettled:
+		if st.N >= 0 {
+			lag = st.N
+		}
+		return latestValue(dps, len(dps)-1-lag)
+	case StrategySum, StrategyMean, StrategyMax:
+		return aggregateValue(st.Name, dps, len(dps)-1-lag, st.N)
+	default:
+		// nonzero: Azion revises the latest datapoints upward, the latest
+		// settled datapoint greater than 0 prevents exporting empty values.
+		v, ts, ok := 0.0, time.Time{}, false
+		for i := len(dps) - 1 - lag; i >= 0; i-- {
+			if dps[i].Null {
+				continue
+			}
+			if !ok {
+				v, ts, ok = dps[i].Value, dps[i].Time, true
+			}
+			if dps[i].Value > 0 {
+				return dps[i].Value, dps[i].Time, true
+			}
+		}
+		return v, ts, ok
+	}
+}
+
+// latestValue return the newest non null datapoint from the position last.
+func latestValue(dps []datapoint, last int) (float64, time.Time, bool) {
+	if last >= len(dps) {
+		last = len(dps) - 1
+	}
+	for i := last; i >= 0; i-- {
+		if !dps[i].Null {
+			return dps[i].Value, dps[i].Time, true
+		}
+	}
+	return 0, time.Time{}, false
+}
+
+// aggregateValue return the aggregation of the n non null datapoints ending
+// at the position last.
+func aggregateValue(name string, dps []datapoint, last, n int) (float64, time.Time, bool) {
+	v, ts, count := 0.0, time.Time{}, 0
+	for i := last; i >= 0 && i > last-n; i-- {
+		if dps[i].Null {
+			continue
+		}
+		if count == 0 {
+			ts = dps[i].Time
+		}
+		switch {
+		case name == StrategyMax && (count == 0 || dps[i].Value > v):
+			v = dps[i].Value
+		case name != StrategyMax:
+			v += dps[i].Value
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, time.Time{}, false
+	}
+	if name == StrategyMean {
+		v /= float64(count)
+	}
+	return v, ts, true
+}
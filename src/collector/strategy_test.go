@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testDatapoints return one datapoint by minute from testStart with the
+// values, NaN being a null datapoint.
+func testDatapoints(values ...float64) []datapoint {
+	dps := make([]datapoint, len(values))
+	for i, v := range values {
+		dps[i] = datapoint{Time: testStart.Add(time.Duration(i) * time.Minute)}
+		if math.IsNaN(v) {
+			dps[i].Null = true
+			continue
+		}
+		dps[i].Value = v
+	}
+	return dps
+}
+
+func TestStrategySelect(t *testing.T) {
+	null := math.NaN()
+	tests := []struct {
+		name     string
+		strategy string
+		values   []float64
+		lag      int
+		want     float64
+		wantAt   int // position of the datapoint time returned
+		wantOK   bool
+	}{
+		{name: "nonzero empty", strategy: "nonzero", lag: 1},
+		{name: "settled empty", strategy: "settled", lag: 1},
+		{name: "latest empty", strategy: "latest"},
+		{name: "sum empty", strategy: "sum:3"},
+
+		{name: "nonzero one unsettled", strategy: "nonzero", values: []float64{5}, lag: 1},
+		{name: "settled one unsettled", strategy: "settled", values: []float64{5}, lag: 1},
+		{name: "latest one", strategy: "latest", values: []float64{5}, lag: 1, want: 5, wantOK: true},
+		{name: "settled:0 one", strategy: "settled:0", values: []float64{5}, lag: 1, want: 5, wantOK: true},
+		{name: "sum one", strategy: "sum:3", values: []float64{5}, want: 5, wantOK: true},
+
+		{name: "nonzero all null", strategy: "nonzero", values: []float64{null, null, null}},
+		{name: "settled all null", strategy: "settled", values: []float64{null, null, null}},
+		{name: "latest all null", strategy: "latest", values: []float64{null, null, null}},
+		{name: "mean all null", strategy: "mean:3", values: []float64{null, null, null}},
+		{name: "max all null", strategy: "max:3", values: []float64{null, null, null}},
+
+		{name: "nonzero lag equal len", strategy: "nonzero", values: []float64{1, 2}, lag: 2},
+		{name: "settled lag over len", strategy: "settled", values: []float64{1, 2}, lag: 5},
+		{name: "sum lag over len", strategy: "sum:2", values: []float64{1, 2}, lag: 5},
+		{name: "latest ignores lag", strategy: "latest", values: []float64{1, 2}, lag: 5, want: 2, wantAt: 1, wantOK: true},
+		{name: "settled:N over len", strategy: "settled:3", values: []float64{1, 2}},
+
+		{name: "latest true zero", strategy: "latest", values: []float64{4, 0, 0}, lag: 1, want: 0, wantAt: 2, wantOK: true},
+		{name: "settled true zero", strategy: "settled", values: []float64{4, 0, 0}, lag: 1, want: 0, wantAt: 1, wantOK: true},
+		{name: "nonzero skips zero", strategy: "nonzero", values: []float64{4, 0, 0}, lag: 1, want: 4, wantOK: true},
+		{name: "nonzero all zero", strategy: "nonzero", values: []float64{0, 0, 7}, lag: 1, want: 0, wantAt: 1, wantOK: true},
+		{name: "nonzero skips null", strategy: "nonzero", values: []float64{5, null, 0, 1}, lag: 1, want: 5, wantOK: true},
+		{name: "settled skips null", strategy: "settled", values: []float64{5, null, 1}, lag: 1, want: 5, wantOK: true},
+		{name: "settled:N overrides lag", strategy: "settled:2", values: []float64{1, 2, 3, 4}, lag: 1, want: 2, wantAt: 1, wantOK: true},
+
+		{name: "sum window", strategy: "sum:2", values: []float64{1, 2, 3, 4, 5}, lag: 1, want: 7, wantAt: 3, wantOK: true},
+		{name: "sum window all settled", strategy: "sum:4", values: []float64{1, 2, 3, 4, 5}, lag: 1, want: 10, wantAt: 3, wantOK: true},
+		{name: "sum window over len", strategy: "sum:10", values: []float64{1, 2, 3, 4, 5}, lag: 1, want: 10, wantAt: 3, wantOK: true},
+		{name: "sum window of one", strategy: "sum", values: []float64{1, 2, 3, 4, 5}, lag: 1, want: 4, wantAt: 3, wantOK: true},
+		{name: "mean window", strategy: "mean:2", values: []float64{1, 2, 3, 4, 5}, lag: 1, want: 3.5, wantAt: 3, wantOK: true},
+		{name: "mean window skips null", strategy: "mean:3", values: []float64{1, 2, null, 4, 5}, lag: 1, want: 3, wantAt: 3, wantOK: true},
+		{name: "sum window null latest", strategy: "sum:2", values: []float64{1, 2, 3, null, 5}, lag: 1, want: 3, wantAt: 2, wantOK: true},
+		{name: "max window excludes older", strategy: "max:3", values: []float64{9, 1, 2, 3, 5}, lag: 1, want: 3, wantAt: 3, wantOK: true},
+		{name: "max window includes oldest", strategy: "max:4", values: []float64{9, 1, 2, 3, 5}, lag: 1, want: 9, wantAt: 3, wantOK: true},
+		{name: "max window excludes unsettled", strategy: "max:2", values: []float64{1, 2, 3, 9}, lag: 1, want: 3, wantAt: 2, wantOK: true},
+		{name: "max negative", strategy: "max:2", values: []float64{-3, -2}, want: -2, wantAt: 1, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := ParseStrategy(tt.strategy)
+			if err != nil {
+				t.Fatalf("ParseStrategy(%q): %v", tt.strategy, err)
+			}
+			dps := testDatapoints(tt.values...)
+			v, ts, ok := st.Select(dps, tt.lag)
+			if ok != tt.wantOK {
+				t.Fatalf("Select() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if v != tt.want {
+				t.Errorf("Select() value = %v, want %v", v, tt.want)
+			}
+			if want := dps[tt.wantAt].Time; !ts.Equal(want) {
+				t.Errorf("Select() time = %v, want %v", ts, want)
+			}
+		})
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Strategy
+		wantErr bool
+	}{
+		{in: "nonzero", want: Strategy{Name: StrategyNonZero}},
+		{in: "latest", want: Strategy{Name: StrategyLatest}},
+		{in: "settled", want: Strategy{Name: StrategySettled, N: -1}},
+		{in: "settled:0", want: Strategy{Name: StrategySettled, N: 0}},
+		{in: "settled:3", want: Strategy{Name: StrategySettled, N: 3}},
+		{in: "sum", want: Strategy{Name: StrategySum, N: 1}},
+		{in: "sum:5", want: Strategy{Name: StrategySum, N: 5}},
+		{in: "mean:2", want: Strategy{Name: StrategyMean, N: 2}},
+		{in: " max:3 ", want: Strategy{Name: StrategyMax, N: 3}},
+
+		{in: "", wantErr: true},
+		{in: "median", wantErr: true},
+		{in: "nonzero:1", wantErr: true},
+		{in: "latest:2", wantErr: true},
+		{in: "settled:-1", wantErr: true},
+		{in: "settled:x", wantErr: true},
+		{in: "sum:0", wantErr: true},
+		{in: "mean:", wantErr: true},
+		{in: "max:-2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseStrategy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStrategy(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseStrategy(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			again, err := ParseStrategy(got.String())
+			if err != nil || again != got {
+				t.Errorf("ParseStrategy(%q.String()) = %+v, %v, want %+v", tt.in, again, err, got)
+			}
+		})
+	}
+}
+
+func TestMetricStrategyDefault(t *testing.T) {
+	ca := &Analytics{config: &Config{}}
+	defs, err := lookupMetricDefinitions(analyticsMetrics, "cd_status_code_5xx", "cd_requests_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ca.metricStrategy(&Metric{Name: defs[0].ID, def: defs[0]}); got != settledStrategy {
+		t.Errorf("status code strategy = %v, want %v", got, settledStrategy)
+	}
+	if got := ca.metricStrategy(&Metric{Name: defs[1].ID, def: defs[1]}); got.Name != StrategyNonZero {
+		t.Errorf("requests strategy = %v, want %s", got, StrategyNonZero)
+	}
+
+	ca.config.Strategies = []StrategyRule{{Pattern: "cd_status_code_*", Strategy: Strategy{Name: StrategyLatest}}}
+	if got := ca.metricStrategy(&Metric{Name: defs[0].ID, def: defs[0]}); got.Name != StrategyLatest {
+		t.Errorf("status code strategy with rule = %v, want %s", got, StrategyLatest)
+	}
+}